@@ -0,0 +1,289 @@
+package fargo
+
+// MIT Licensed (see README.md) - Copyright (c) 2013 Hudl <@Hudl>
+
+import (
+	"errors"
+	"math/rand"
+	"net"
+	"strconv"
+	"sync"
+	"sync/atomic"
+)
+
+// ErrNoInstances indicates that a Balancer has no instances from which to pick.
+var ErrNoInstances = errors.New("no instances available to pick")
+
+// A Candidate is an instance eligible for selection by a Balancer, together with the address at
+// which to reach it.
+type Candidate struct {
+	Instance *Instance
+	// Addr is the instance's address in the form "host:port", ready to dial.
+	Addr string
+	// inFlight counts the outstanding selections of this instance, shared by successive
+	// candidates for the same instance as the balancer's set of instances changes.
+	inFlight *int64
+}
+
+// InFlight returns the number of selections of this candidate that have yet to be marked done.
+func (c *Candidate) InFlight() int64 {
+	return atomic.LoadInt64(c.inFlight)
+}
+
+// A Strategy chooses among the candidates available to a Balancer.
+type Strategy interface {
+	// Choose returns the index of the chosen candidate in the given nonempty slice. It may be
+	// called concurrently.
+	Choose(candidates []*Candidate) int
+}
+
+type roundRobin struct {
+	next uint64
+}
+
+func (s *roundRobin) Choose(candidates []*Candidate) int {
+	return int((atomic.AddUint64(&s.next, 1) - 1) % uint64(len(candidates)))
+}
+
+// RoundRobin returns a Strategy that chooses each candidate in turn.
+func RoundRobin() Strategy {
+	return &roundRobin{}
+}
+
+type random struct{}
+
+func (random) Choose(candidates []*Candidate) int {
+	return rand.Intn(len(candidates))
+}
+
+// Random returns a Strategy that chooses a candidate uniformly at random, using the default shared
+// rand.Source.
+func Random() Strategy {
+	return random{}
+}
+
+type powerOfTwoChoices struct{}
+
+func (powerOfTwoChoices) Choose(candidates []*Candidate) int {
+	n := len(candidates)
+	if n == 1 {
+		return 0
+	}
+	i := rand.Intn(n)
+	j := rand.Intn(n - 1)
+	if j >= i {
+		j++
+	}
+	if candidates[j].InFlight() < candidates[i].InFlight() {
+		return j
+	}
+	return i
+}
+
+// PowerOfTwoChoices returns a Strategy that considers two distinct candidates chosen at random,
+// and chooses whichever of the two has fewer selections in flight.
+func PowerOfTwoChoices() Strategy {
+	return powerOfTwoChoices{}
+}
+
+type weightedByMetadata struct {
+	key           string
+	defaultWeight float64
+}
+
+func (s weightedByMetadata) weight(c *Candidate) float64 {
+	// NB: Read the already parsed metadata directly, as parsing it again would race with other
+	// callers. The source parses each instance's metadata before offering it.
+	w := -1.0
+	switch v := c.Instance.Metadata.parsed[s.key].(type) {
+	case string:
+		if f, err := strconv.ParseFloat(v, 64); err == nil {
+			w = f
+		}
+	case float64:
+		w = v
+	}
+	if w < 0 {
+		return s.defaultWeight
+	}
+	return w
+}
+
+func (s weightedByMetadata) Choose(candidates []*Candidate) int {
+	weights := make([]float64, len(candidates))
+	var total float64
+	for i, c := range candidates {
+		weights[i] = s.weight(c)
+		total += weights[i]
+	}
+	if total <= 0 {
+		return rand.Intn(len(candidates))
+	}
+	r := rand.Float64() * total
+	for i, w := range weights {
+		if r < w {
+			return i
+		}
+		r -= w
+	}
+	return len(candidates) - 1
+}
+
+// WeightedByMetadata returns a Strategy that chooses a candidate at random, with probability
+// proportional to the weight given by the value of the instance metadata item with the given key.
+// Instances lacking a valid, nonnegative weight in that item are given the default weight. If every
+// candidate has a weight of zero, it chooses uniformly at random.
+func WeightedByMetadata(key string, defaultWeight float64) Strategy {
+	return weightedByMetadata{key, defaultWeight}
+}
+
+type balancerOptions struct {
+	secure bool
+	useIP  bool
+}
+
+// BalancerOption customizes how a Balancer reaches the instances it picks.
+type BalancerOption func(*balancerOptions)
+
+// UsingSecurePort directs a Balancer to pick only among instances with their secure port enabled,
+// addressing them at that port. By default, a Balancer picks only among instances with their
+// insecure port enabled, addressing them at that port.
+func UsingSecurePort(o *balancerOptions) {
+	o.secure = true
+}
+
+// UsingIPAddress directs a Balancer to address instances by their IP address rather than their
+// host name.
+func UsingIPAddress(o *balancerOptions) {
+	o.useIP = true
+}
+
+// A Balancer picks among the instances offered by an InstanceSetSource, skipping those that are
+// not UP or lack an enabled port of the requested kind. It follows the source's changes as they
+// occur, without blocking concurrent callers of Pick.
+type Balancer struct {
+	strategy    Strategy
+	opts        balancerOptions
+	candidates  atomic.Value // []*Candidate
+	m           sync.Mutex
+	inFlight    map[string]*int64
+	unsubscribe func()
+}
+
+// NewBalancer returns a Balancer that picks among the instances offered by the given source
+// using the given strategy. The Balancer remains subscribed to the source until its Close method
+// is called; closing the Balancer does not stop the source.
+func NewBalancer(source *InstanceSetSource, strategy Strategy, opts ...BalancerOption) *Balancer {
+	b := &Balancer{
+		strategy: strategy,
+		inFlight: make(map[string]*int64),
+	}
+	for _, o := range opts {
+		if o != nil {
+			o(&b.opts)
+		}
+	}
+	b.candidates.Store([]*Candidate(nil))
+	b.unsubscribe = source.Subscribe(func([]InstanceChange) {
+		b.update(source.Latest())
+	})
+	return b
+}
+
+func (b *Balancer) address(ins *Instance) (string, bool) {
+	port, enabled := ins.Port, ins.PortEnabled
+	if b.opts.secure {
+		port, enabled = ins.SecurePort, ins.SecurePortEnabled
+	}
+	if !enabled {
+		return "", false
+	}
+	host := ins.HostName
+	if b.opts.useIP || len(host) == 0 {
+		host = ins.IPAddr
+	}
+	if len(host) == 0 {
+		return "", false
+	}
+	return net.JoinHostPort(host, strconv.Itoa(port)), true
+}
+
+// update replaces the balancer's candidates with those drawn from the given instances, carrying
+// over the in-flight counts of instances that remain.
+func (b *Balancer) update(instances []*Instance) {
+	b.m.Lock()
+	defer b.m.Unlock()
+	candidates := make([]*Candidate, 0, len(instances))
+	inFlight := make(map[string]*int64, len(instances))
+	for _, ins := range instances {
+		if ins.Status != UP {
+			continue
+		}
+		addr, ok := b.address(ins)
+		if !ok {
+			continue
+		}
+		id := ins.Id()
+		count, ok := b.inFlight[id]
+		if !ok {
+			count = new(int64)
+		}
+		inFlight[id] = count
+		candidates = append(candidates, &Candidate{ins, addr, count})
+	}
+	b.inFlight = inFlight
+	b.candidates.Store(candidates)
+}
+
+// Candidates returns the instances among which the Balancer currently picks.
+func (b *Balancer) Candidates() []*Candidate {
+	candidates := b.candidates.Load().([]*Candidate)
+	return append([]*Candidate(nil), candidates...)
+}
+
+// A Selection is an instance picked by a Balancer. Call its Done method once finished with the
+// instance, so that strategies that consider outstanding selections can account for it.
+type Selection struct {
+	*Candidate
+	once sync.Once
+}
+
+// Done marks the selection as no longer in flight. It is safe to call more than once.
+func (s *Selection) Done() {
+	s.once.Do(func() {
+		atomic.AddInt64(s.inFlight, -1)
+	})
+}
+
+// Pick chooses an instance per the balancer's strategy, returning ErrNoInstances if there are no
+// candidates from which to choose.
+func (b *Balancer) Pick() (*Selection, error) {
+	return b.pick(nil)
+}
+
+// pick chooses an instance per the balancer's strategy from among those candidates whose instance
+// IDs are not excluded.
+func (b *Balancer) pick(exclude []string) (*Selection, error) {
+	candidates := b.candidates.Load().([]*Candidate)
+	if len(exclude) > 0 {
+		remaining := make([]*Candidate, 0, len(candidates))
+		for _, c := range candidates {
+			if !contains(exclude, c.Instance.Id()) {
+				remaining = append(remaining, c)
+			}
+		}
+		candidates = remaining
+	}
+	if len(candidates) == 0 {
+		return nil, ErrNoInstances
+	}
+	c := candidates[b.strategy.Choose(candidates)]
+	atomic.AddInt64(c.inFlight, 1)
+	return &Selection{Candidate: c}, nil
+}
+
+// Close unsubscribes the Balancer from its source, so that it no longer follows the source's
+// changes. It continues to pick among its last set of candidates.
+func (b *Balancer) Close() {
+	b.unsubscribe()
+}
@@ -0,0 +1,173 @@
+package fargo
+
+// MIT Licensed (see README.md) - Copyright (c) 2013 Hudl <@Hudl>
+
+import (
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func reachableInstance(id string, status StatusType, port int, metadata map[string]string) *Instance {
+	ins := instanceWith(id, status, metadata)
+	ins.HostName = id + ".example.com"
+	ins.IPAddr = "10.0.0.1"
+	ins.Port = port
+	ins.PortEnabled = true
+	ins.SecurePort = port + 1
+	return ins
+}
+
+func candidateAddrs(candidates []*Candidate) []string {
+	addrs := make([]string, len(candidates))
+	for i, c := range candidates {
+		addrs[i] = c.Addr
+	}
+	return addrs
+}
+
+func TestBalancer(t *testing.T) {
+	Convey("Given a balancer fed by an instance set source", t, func() {
+		results := make(chan []*Instance)
+		produce := func() ([]*Instance, error) {
+			return <-results, nil
+		}
+		secure := reachableInstance("i-3", UP, 8080, nil)
+		secure.SecurePortEnabled = true
+		go func() {
+			results <- []*Instance{
+				reachableInstance("i-1", UP, 8080, nil),
+				reachableInstance("i-2", DOWN, 8080, nil),
+				secure,
+			}
+		}()
+		e := EurekaConnection{PollInterval: time.Millisecond}
+		s := e.newInstanceSetSourceFor(produce, true)
+		defer s.Stop()
+
+		Convey("it picks only among the instances that are UP", func() {
+			b := NewBalancer(s, RoundRobin())
+			defer b.Close()
+			So(candidateAddrs(b.Candidates()), ShouldResemble, []string{"i-1.example.com:8080", "i-3.example.com:8080"})
+
+			Convey("following the source's changes", func() {
+				results <- []*Instance{reachableInstance("i-2", UP, 9090, nil)}
+				// Wait for the last poll to be consumed.
+				results <- []*Instance{reachableInstance("i-2", UP, 9090, nil)}
+				So(candidateAddrs(b.Candidates()), ShouldResemble, []string{"i-2.example.com:9090"})
+
+				Convey("until it's closed", func() {
+					b.Close()
+					results <- []*Instance{}
+					results <- []*Instance{}
+					So(candidateAddrs(b.Candidates()), ShouldResemble, []string{"i-2.example.com:9090"})
+				})
+			})
+
+			Convey("following instances that move to another address", func() {
+				moved := []*Instance{
+					reachableInstance("i-1", UP, 9090, nil),
+					reachableInstance("i-2", DOWN, 8080, nil),
+					secure,
+				}
+				results <- moved
+				results <- moved
+				So(candidateAddrs(b.Candidates()), ShouldResemble, []string{"i-1.example.com:9090", "i-3.example.com:8080"})
+			})
+
+			Convey("and reports when none remain", func() {
+				results <- []*Instance{reachableInstance("i-1", STARTING, 8080, nil)}
+				results <- []*Instance{}
+				_, err := b.Pick()
+				So(err, ShouldEqual, ErrNoInstances)
+			})
+		})
+
+		Convey("it can address instances by their secure port and IP address", func() {
+			b := NewBalancer(s, RoundRobin(), UsingSecurePort, UsingIPAddress)
+			defer b.Close()
+			So(candidateAddrs(b.Candidates()), ShouldResemble, []string{"10.0.0.1:8081"})
+		})
+
+		Convey("it tracks the selections in flight", func() {
+			b := NewBalancer(s, RoundRobin())
+			defer b.Close()
+			first, err := b.Pick()
+			So(err, ShouldBeNil)
+			So(first.Instance.Id(), ShouldEqual, "i-1")
+			So(first.InFlight(), ShouldEqual, 1)
+
+			Convey("across changes to the source", func() {
+				results <- []*Instance{reachableInstance("i-1", UP, 8080, nil)}
+				results <- []*Instance{reachableInstance("i-1", UP, 8080, nil)}
+				So(b.Candidates()[0].InFlight(), ShouldEqual, 1)
+				first.Done()
+				first.Done()
+				So(b.Candidates()[0].InFlight(), ShouldEqual, 0)
+			})
+		})
+	})
+}
+
+func TestStrategies(t *testing.T) {
+	candidatesFor := func(instances ...*Instance) []*Candidate {
+		candidates := make([]*Candidate, len(instances))
+		for i, ins := range instances {
+			candidates[i] = &Candidate{Instance: ins, Addr: ins.Id(), inFlight: new(int64)}
+		}
+		return candidates
+	}
+
+	Convey("Round robin chooses each candidate in turn", t, func() {
+		candidates := candidatesFor(instanceWith("a", UP, nil), instanceWith("b", UP, nil), instanceWith("c", UP, nil))
+		s := RoundRobin()
+		var chosen []int
+		for i := 0; i < 5; i++ {
+			chosen = append(chosen, s.Choose(candidates))
+		}
+		So(chosen, ShouldResemble, []int{0, 1, 2, 0, 1})
+	})
+
+	Convey("Random chooses among all the candidates", t, func() {
+		candidates := candidatesFor(instanceWith("a", UP, nil), instanceWith("b", UP, nil))
+		s := Random()
+		for i := 0; i < 20; i++ {
+			So(s.Choose(candidates), ShouldBeBetweenOrEqual, 0, 1)
+		}
+	})
+
+	Convey("Power of two choices prefers the candidate with fewer selections in flight", t, func() {
+		candidates := candidatesFor(instanceWith("a", UP, nil), instanceWith("b", UP, nil))
+		*candidates[0].inFlight = 3
+		s := PowerOfTwoChoices()
+		for i := 0; i < 20; i++ {
+			So(s.Choose(candidates), ShouldEqual, 1)
+		}
+		So(s.Choose(candidates[:1]), ShouldEqual, 0)
+	})
+
+	Convey("Weighting by metadata chooses in proportion to each candidate's weight", t, func() {
+		candidates := candidatesFor(
+			instanceWith("a", UP, map[string]string{"weight": "0"}),
+			instanceWith("b", UP, map[string]string{"weight": "bogus"}),
+			instanceWith("c", UP, nil),
+			instanceWith("d", UP, map[string]string{"weight": "2"}),
+		)
+		s := WeightedByMetadata("weight", 0)
+		for i := 0; i < 20; i++ {
+			So(s.Choose(candidates), ShouldEqual, 3)
+		}
+
+		Convey("falling back to the default weight", func() {
+			s := WeightedByMetadata("weight", 1)
+			counts := make([]int, len(candidates))
+			for i := 0; i < 400; i++ {
+				counts[s.Choose(candidates)]++
+			}
+			So(counts[0], ShouldEqual, 0)
+			So(counts[1], ShouldBeGreaterThan, 0)
+			So(counts[3], ShouldBeGreaterThan, counts[2])
+		})
+	})
+}
@@ -0,0 +1,407 @@
+package fargo
+
+// MIT Licensed (see README.md) - Copyright (c) 2013 Hudl <@Hudl>
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Environment variables consulted by an InstanceBuilder whose WithEnvironment method was called.
+const (
+	EnvInstanceID       = "EUREKA_INSTANCE_ID"
+	EnvHostName         = "EUREKA_INSTANCE_HOSTNAME"
+	EnvIPAddress        = "EUREKA_INSTANCE_IP_ADDRESS"
+	EnvPort             = "EUREKA_INSTANCE_PORT"
+	EnvSecurePort       = "EUREKA_INSTANCE_SECURE_PORT"
+	EnvVIPAddress       = "EUREKA_INSTANCE_VIP_ADDRESS"
+	EnvSecureVIPAddress = "EUREKA_INSTANCE_SECURE_VIP_ADDRESS"
+)
+
+const (
+	defaultLeaseDuration   = 90 * time.Second
+	defaultStatusPagePath  = "/status"
+	defaultHealthCheckPath = "/healthcheck"
+)
+
+// interfaceAddr is an IP address assigned to a network interface.
+type interfaceAddr struct {
+	iface string
+	ip    net.IP
+}
+
+// systemInterfaceAddrs returns the IP addresses assigned to the host's network interfaces that are
+// up and are not loopback interfaces.
+func systemInterfaceAddrs() ([]interfaceAddr, error) {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return nil, err
+	}
+	var addrs []interfaceAddr
+	for _, iface := range ifaces {
+		if iface.Flags&net.FlagUp == 0 || iface.Flags&net.FlagLoopback != 0 {
+			continue
+		}
+		ifaceAddrs, err := iface.Addrs()
+		if err != nil {
+			return nil, err
+		}
+		for _, a := range ifaceAddrs {
+			if ipNet, ok := a.(*net.IPNet); ok {
+				addrs = append(addrs, interfaceAddr{iface.Name, ipNet.IP})
+			}
+		}
+	}
+	return addrs, nil
+}
+
+// An InstanceBuilder assembles an Instance fit for registration with Eureka, detecting the host's
+// name and IP address and deriving the remaining fields from the application name and ports
+// supplied. Each of its methods returns the builder, so that calls may be chained:
+//
+//	ins, err := fargo.NewInstanceBuilder("TESTAPP").
+//		WithPort(8080).
+//		WithCIDR("10.0.0.0/8").
+//		WithEnvironment().
+//		Build()
+type InstanceBuilder struct {
+	app             string
+	id              string
+	hostName        string
+	ipAddr          string
+	iface           string
+	cidr            *net.IPNet
+	port            int
+	securePort      int
+	vip             string
+	secureVIP       string
+	homePagePath    string
+	statusPagePath  string
+	healthCheckPath string
+	status          StatusType
+	dataCenterInfo  DataCenterInfo
+	renewalInterval time.Duration
+	leaseDuration   time.Duration
+	metadata        map[string]string
+	useEnv          bool
+	err             error
+	getenv          func(string) string
+	hostname        func() (string, error)
+	interfaceAddrs  func() ([]interfaceAddr, error)
+}
+
+// NewInstanceBuilder returns an InstanceBuilder for an instance of the application with the given
+// name.
+func NewInstanceBuilder(app string) *InstanceBuilder {
+	return &InstanceBuilder{
+		app:             app,
+		homePagePath:    "/",
+		statusPagePath:  defaultStatusPagePath,
+		healthCheckPath: defaultHealthCheckPath,
+		status:          UP,
+		dataCenterInfo:  DataCenterInfo{Name: MyOwn},
+		renewalInterval: defaultRenewalInterval,
+		leaseDuration:   defaultLeaseDuration,
+		getenv:          os.Getenv,
+		hostname:        os.Hostname,
+		interfaceAddrs:  systemInterfaceAddrs,
+	}
+}
+
+func (b *InstanceBuilder) fail(err error) *InstanceBuilder {
+	if b.err == nil {
+		b.err = err
+	}
+	return b
+}
+
+// WithInstanceID sets the instance's ID. By default, the instance has no ID of its own, such that
+// Eureka identifies it by its host name.
+func (b *InstanceBuilder) WithInstanceID(id string) *InstanceBuilder {
+	b.id = id
+	return b
+}
+
+// WithHostName sets the instance's host name, rather than using the name the host reports.
+func (b *InstanceBuilder) WithHostName(name string) *InstanceBuilder {
+	b.hostName = name
+	return b
+}
+
+// WithIPAddress sets the instance's IP address, rather than detecting one.
+func (b *InstanceBuilder) WithIPAddress(addr string) *InstanceBuilder {
+	if net.ParseIP(addr) == nil {
+		return b.fail(fmt.Errorf("invalid IP address %q", addr))
+	}
+	b.ipAddr = addr
+	return b
+}
+
+// WithInterface restricts IP address detection to the network interface with the given name. By
+// default, the builder considers every interface that is up and is not a loopback interface,
+// preferring those that aren't bridge or virtual interfaces, such as docker0.
+func (b *InstanceBuilder) WithInterface(name string) *InstanceBuilder {
+	b.iface = name
+	return b
+}
+
+// WithCIDR restricts IP address detection to addresses within the given CIDR block, such as
+// "10.0.0.0/8".
+func (b *InstanceBuilder) WithCIDR(cidr string) *InstanceBuilder {
+	_, ipNet, err := net.ParseCIDR(cidr)
+	if err != nil {
+		return b.fail(err)
+	}
+	b.cidr = ipNet
+	return b
+}
+
+// WithPort enables the instance's insecure port, setting it to the given port.
+func (b *InstanceBuilder) WithPort(port int) *InstanceBuilder {
+	if port <= 0 || port > 65535 {
+		return b.fail(fmt.Errorf("invalid port %d", port))
+	}
+	b.port = port
+	return b
+}
+
+// WithSecurePort enables the instance's secure port, setting it to the given port.
+func (b *InstanceBuilder) WithSecurePort(port int) *InstanceBuilder {
+	if port <= 0 || port > 65535 {
+		return b.fail(fmt.Errorf("invalid secure port %d", port))
+	}
+	b.securePort = port
+	return b
+}
+
+// WithVIPAddress sets the instance's VIP address. By default, it's the application name in lower
+// case.
+func (b *InstanceBuilder) WithVIPAddress(addr string) *InstanceBuilder {
+	b.vip = addr
+	return b
+}
+
+// WithSecureVIPAddress sets the instance's secure VIP address. By default, if the instance's
+// secure port is enabled, it's the application name in lower case.
+func (b *InstanceBuilder) WithSecureVIPAddress(addr string) *InstanceBuilder {
+	b.secureVIP = addr
+	return b
+}
+
+// WithHomePagePath sets the path of the instance's home page URL. By default, it's "/".
+func (b *InstanceBuilder) WithHomePagePath(path string) *InstanceBuilder {
+	b.homePagePath = path
+	return b
+}
+
+// WithStatusPagePath sets the path of the instance's status page URL. By default, it's "/status",
+// matching the path served by a HealthReporter's handler.
+func (b *InstanceBuilder) WithStatusPagePath(path string) *InstanceBuilder {
+	b.statusPagePath = path
+	return b
+}
+
+// WithHealthCheckPath sets the path of the instance's health check URL. By default, it's
+// "/healthcheck", matching the path served by a HealthReporter's handler.
+func (b *InstanceBuilder) WithHealthCheckPath(path string) *InstanceBuilder {
+	b.healthCheckPath = path
+	return b
+}
+
+// WithStatus sets the status with which the instance registers. By default, it's UP.
+func (b *InstanceBuilder) WithStatus(status StatusType) *InstanceBuilder {
+	b.status = status
+	return b
+}
+
+// WithDataCenterInfo sets the instance's data center information. By default, the instance runs
+// in its own data center, named "MyOwn".
+func (b *InstanceBuilder) WithDataCenterInfo(dci DataCenterInfo) *InstanceBuilder {
+	b.dataCenterInfo = dci
+	return b
+}
+
+// WithLease sets the interval at which the instance renews its lease, and the time Eureka allows
+// to pass without a renewal before expiring the lease. By default, these are 30 and 90 seconds,
+// respectively, matching the Eureka server's defaults.
+func (b *InstanceBuilder) WithLease(renewalInterval, duration time.Duration) *InstanceBuilder {
+	if renewalInterval < time.Second || duration < renewalInterval {
+		return b.fail(fmt.Errorf("invalid lease renewal interval %v and duration %v", renewalInterval, duration))
+	}
+	b.renewalInterval = renewalInterval
+	b.leaseDuration = duration
+	return b
+}
+
+// WithMetadata sets a metadata item for the instance.
+func (b *InstanceBuilder) WithMetadata(key, value string) *InstanceBuilder {
+	if b.metadata == nil {
+		b.metadata = make(map[string]string)
+	}
+	b.metadata[key] = value
+	return b
+}
+
+// WithEnvironment directs the builder to take the values of the following environment variables,
+// where set, in preference to any supplied to its other methods or detected from the host:
+//
+//	EUREKA_INSTANCE_ID
+//	EUREKA_INSTANCE_HOSTNAME
+//	EUREKA_INSTANCE_IP_ADDRESS
+//	EUREKA_INSTANCE_PORT
+//	EUREKA_INSTANCE_SECURE_PORT
+//	EUREKA_INSTANCE_VIP_ADDRESS
+//	EUREKA_INSTANCE_SECURE_VIP_ADDRESS
+func (b *InstanceBuilder) WithEnvironment() *InstanceBuilder {
+	b.useEnv = true
+	return b
+}
+
+func (b *InstanceBuilder) applyEnvironment() {
+	set := func(name string, apply func(string) *InstanceBuilder) {
+		if v := b.getenv(name); len(v) > 0 {
+			apply(v)
+		}
+	}
+	port := func(apply func(int) *InstanceBuilder, name string) func(string) *InstanceBuilder {
+		return func(v string) *InstanceBuilder {
+			p, err := strconv.Atoi(v)
+			if err != nil {
+				return b.fail(fmt.Errorf("invalid value %q for environment variable %s", v, name))
+			}
+			return apply(p)
+		}
+	}
+	set(EnvInstanceID, b.WithInstanceID)
+	set(EnvHostName, b.WithHostName)
+	set(EnvIPAddress, b.WithIPAddress)
+	set(EnvPort, port(b.WithPort, EnvPort))
+	set(EnvSecurePort, port(b.WithSecurePort, EnvSecurePort))
+	set(EnvVIPAddress, b.WithVIPAddress)
+	set(EnvSecureVIPAddress, b.WithSecureVIPAddress)
+}
+
+// virtualInterfacePrefixes begin the names of the bridge and virtual interfaces that container
+// runtimes and hypervisors create, whose addresses other hosts generally can't reach.
+var virtualInterfacePrefixes = []string{"docker", "br-", "veth", "cni", "virbr"}
+
+func isVirtualInterface(name string) bool {
+	for _, p := range virtualInterfacePrefixes {
+		if strings.HasPrefix(name, p) {
+			return true
+		}
+	}
+	return false
+}
+
+// detectIPAddress returns the first IPv4 address assigned to an eligible interface, or failing
+// that, the first such IPv6 address that is not link-local. It passes over bridge and virtual
+// interfaces, such as docker0, unless they're all there is or WithInterface names one.
+func (b *InstanceBuilder) detectIPAddress() (string, error) {
+	addrs, err := b.interfaceAddrs()
+	if err != nil {
+		return "", err
+	}
+	var v4, v6, virtualV4, virtualV6 net.IP
+	for _, a := range addrs {
+		if len(b.iface) > 0 && a.iface != b.iface {
+			continue
+		}
+		if b.cidr != nil && !b.cidr.Contains(a.ip) {
+			continue
+		}
+		if a.ip.IsLoopback() || a.ip.IsLinkLocalUnicast() {
+			continue
+		}
+		first, firstV6 := &v4, &v6
+		if len(b.iface) == 0 && isVirtualInterface(a.iface) {
+			first, firstV6 = &virtualV4, &virtualV6
+		}
+		if a.ip.To4() == nil {
+			first = firstV6
+		}
+		if *first == nil {
+			*first = a.ip
+		}
+	}
+	for _, ip := range []net.IP{v4, v6, virtualV4, virtualV6} {
+		if ip != nil {
+			return ip.String(), nil
+		}
+	}
+	return "", errors.New("found no IP address for the instance")
+}
+
+// Build returns the assembled instance, or the first error encountered while assembling it.
+func (b *InstanceBuilder) Build() (*Instance, error) {
+	if b.useEnv {
+		b.applyEnvironment()
+	}
+	if b.err != nil {
+		return nil, b.err
+	}
+	if len(b.app) == 0 {
+		return nil, errors.New("instance lacks an application name")
+	}
+	if b.port == 0 && b.securePort == 0 {
+		return nil, errors.New("instance lacks a port")
+	}
+	ipAddr := b.ipAddr
+	if len(ipAddr) == 0 {
+		var err error
+		if ipAddr, err = b.detectIPAddress(); err != nil {
+			return nil, err
+		}
+	}
+	hostName := b.hostName
+	if len(hostName) == 0 {
+		if name, err := b.hostname(); err == nil && len(name) > 0 {
+			hostName = name
+		} else {
+			hostName = ipAddr
+		}
+	}
+	vip := b.vip
+	if len(vip) == 0 {
+		vip = strings.ToLower(b.app)
+	}
+	secureVIP := b.secureVIP
+	if len(secureVIP) == 0 && b.securePort > 0 {
+		secureVIP = strings.ToLower(b.app)
+	}
+	scheme, port := "http", b.port
+	if port == 0 {
+		scheme, port = "https", b.securePort
+	}
+	base := scheme + "://" + net.JoinHostPort(hostName, strconv.Itoa(port))
+	ins := &Instance{
+		InstanceId:        b.id,
+		HostName:          hostName,
+		App:               strings.ToUpper(b.app),
+		IPAddr:            ipAddr,
+		VipAddress:        vip,
+		SecureVipAddress:  secureVIP,
+		Status:            b.status,
+		Port:              b.port,
+		PortEnabled:       b.port > 0,
+		SecurePort:        b.securePort,
+		SecurePortEnabled: b.securePort > 0,
+		HomePageUrl:       base + b.homePagePath,
+		StatusPageUrl:     base + b.statusPagePath,
+		HealthCheckUrl:    base + b.healthCheckPath,
+		DataCenterInfo:    b.dataCenterInfo,
+		LeaseInfo: LeaseInfo{
+			RenewalIntervalInSecs: int32(b.renewalInterval / time.Second),
+			DurationInSecs:        int32(b.leaseDuration / time.Second),
+		},
+	}
+	for k, v := range b.metadata {
+		ins.SetMetadataString(k, v)
+	}
+	return ins, nil
+}
@@ -0,0 +1,130 @@
+package fargo
+
+// MIT Licensed (see README.md) - Copyright (c) 2013 Hudl <@Hudl>
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestInstanceBuilder(t *testing.T) {
+	Convey("Given an instance builder on a host with several interfaces", t, func() {
+		env := map[string]string{}
+		b := NewInstanceBuilder("testapp")
+		b.getenv = func(name string) string { return env[name] }
+		b.hostname = func() (string, error) { return "host.example.com", nil }
+		b.interfaceAddrs = func() ([]interfaceAddr, error) {
+			return []interfaceAddr{
+				{"eth0", net.ParseIP("fe80::1")},
+				{"docker0", net.ParseIP("172.17.0.1")},
+				{"eth0", net.ParseIP("10.1.2.3")},
+				{"eth1", net.ParseIP("2001:db8::5")},
+			}, nil
+		}
+
+		Convey("it derives the instance's fields from its application name and port", func() {
+			ins, err := b.WithPort(8080).WithMetadata("version", "1.0").Build()
+			So(err, ShouldBeNil)
+			So(ins.App, ShouldEqual, "TESTAPP")
+			So(ins.HostName, ShouldEqual, "host.example.com")
+			So(ins.IPAddr, ShouldEqual, "10.1.2.3")
+			So(ins.VipAddress, ShouldEqual, "testapp")
+			So(ins.SecureVipAddress, ShouldBeEmpty)
+			So(ins.Status, ShouldEqual, UP)
+			So(ins.Port, ShouldEqual, 8080)
+			So(ins.PortEnabled, ShouldBeTrue)
+			So(ins.SecurePortEnabled, ShouldBeFalse)
+			So(ins.HomePageUrl, ShouldEqual, "http://host.example.com:8080/")
+			So(ins.StatusPageUrl, ShouldEqual, "http://host.example.com:8080/status")
+			So(ins.HealthCheckUrl, ShouldEqual, "http://host.example.com:8080/healthcheck")
+			So(ins.DataCenterInfo.Name, ShouldEqual, MyOwn)
+			So(ins.LeaseInfo.RenewalIntervalInSecs, ShouldEqual, 30)
+			So(ins.LeaseInfo.DurationInSecs, ShouldEqual, 90)
+			So(ins.Metadata.GetMap(), ShouldResemble, map[string]interface{}{"version": "1.0"})
+			So(ins.Id(), ShouldEqual, "host.example.com")
+		})
+
+		Convey("it addresses a secure-only instance via HTTPS", func() {
+			ins, err := b.WithSecurePort(8443).WithLease(10*time.Second, 30*time.Second).Build()
+			So(err, ShouldBeNil)
+			So(ins.PortEnabled, ShouldBeFalse)
+			So(ins.SecurePortEnabled, ShouldBeTrue)
+			So(ins.SecureVipAddress, ShouldEqual, "testapp")
+			So(ins.HealthCheckUrl, ShouldEqual, "https://host.example.com:8443/healthcheck")
+			So(ins.LeaseInfo.RenewalIntervalInSecs, ShouldEqual, 10)
+		})
+
+		Convey("it selects the IP address by interface", func() {
+			ins, err := b.WithPort(80).WithInterface("eth0").Build()
+			So(err, ShouldBeNil)
+			So(ins.IPAddr, ShouldEqual, "10.1.2.3")
+		})
+
+		Convey("it uses a bridge interface only when named or when there's no other", func() {
+			ins, err := b.WithPort(80).WithInterface("docker0").Build()
+			So(err, ShouldBeNil)
+			So(ins.IPAddr, ShouldEqual, "172.17.0.1")
+
+			b.interfaceAddrs = func() ([]interfaceAddr, error) {
+				return []interfaceAddr{{"veth1a2b", net.ParseIP("172.18.0.4")}}, nil
+			}
+			ins, err = b.WithInterface("").Build()
+			So(err, ShouldBeNil)
+			So(ins.IPAddr, ShouldEqual, "172.18.0.4")
+		})
+
+		Convey("it selects the IP address by CIDR block", func() {
+			ins, err := b.WithPort(80).WithCIDR("2001:db8::/32").Build()
+			So(err, ShouldBeNil)
+			So(ins.IPAddr, ShouldEqual, "2001:db8::5")
+
+			_, err = NewInstanceBuilder("testapp").WithPort(80).WithCIDR("10.0.0.0").Build()
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("it fails when no IP address matches", func() {
+			_, err := b.WithPort(80).WithInterface("wlan0").Build()
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("it falls back to the IP address when the host name is unavailable", func() {
+			b.hostname = func() (string, error) { return "", errors.New("unavailable") }
+			ins, err := b.WithPort(80).Build()
+			So(err, ShouldBeNil)
+			So(ins.HostName, ShouldEqual, "10.1.2.3")
+		})
+
+		Convey("it takes overrides from the environment", func() {
+			env[EnvHostName] = "public.example.com"
+			env[EnvIPAddress] = "203.0.113.7"
+			env[EnvPort] = "9090"
+			env[EnvInstanceID] = "i-1"
+			env[EnvVIPAddress] = "testapp-canary"
+			ins, err := b.WithPort(8080).WithHostName("ignored").WithEnvironment().Build()
+			So(err, ShouldBeNil)
+			So(ins.Id(), ShouldEqual, "i-1")
+			So(ins.HostName, ShouldEqual, "public.example.com")
+			So(ins.IPAddr, ShouldEqual, "203.0.113.7")
+			So(ins.Port, ShouldEqual, 9090)
+			So(ins.VipAddress, ShouldEqual, "testapp-canary")
+			So(ins.HomePageUrl, ShouldEqual, "http://public.example.com:9090/")
+
+			Convey("rejecting invalid values", func() {
+				env[EnvPort] = "http"
+				b := NewInstanceBuilder("testapp")
+				b.getenv = func(name string) string { return env[name] }
+				_, err := b.WithPort(80).WithEnvironment().Build()
+				So(err, ShouldNotBeNil)
+			})
+		})
+
+		Convey("it requires a port", func() {
+			_, err := b.Build()
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
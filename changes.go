@@ -0,0 +1,169 @@
+package fargo
+
+// MIT Licensed (see README.md) - Copyright (c) 2013 Hudl <@Hudl>
+
+import (
+	"reflect"
+	"sync"
+)
+
+// InstanceChangeType is an enum of the ways in which an instance can differ between successive
+// snapshots of a set of instances.
+type InstanceChangeType string
+
+// Supported instance change types
+const (
+	InstanceAdded           InstanceChangeType = "ADDED"
+	InstanceRemoved         InstanceChangeType = "REMOVED"
+	InstanceStatusChanged   InstanceChangeType = "STATUS_CHANGED"
+	InstanceMetadataChanged InstanceChangeType = "METADATA_CHANGED"
+	InstanceAddressChanged  InstanceChangeType = "ADDRESS_CHANGED"
+)
+
+// An InstanceChange describes how an instance, identified by its Id method, differs between two
+// successive snapshots of a set of instances.
+type InstanceChange struct {
+	Type InstanceChangeType
+	// Instance is the instance as it appears in the later snapshot, or for a removed instance, as
+	// it appeared in the earlier one.
+	Instance *Instance
+	// Previous is the instance as it appeared in the earlier snapshot. It's nil for added and
+	// removed instances.
+	Previous *Instance
+}
+
+// diffInstances returns the changes that transform the set of instances prev into next, comparing
+// the instances' parsed metadata. Changes to instances present in next come first, in next's
+// order, followed by removals, in prev's order. An instance that changed in more than one way,
+// such as in both status and metadata, yields a change of each type.
+func diffInstances(prev, next []*Instance) []InstanceChange {
+	byID := make(map[string]*Instance, len(prev))
+	for _, ins := range prev {
+		byID[ins.Id()] = ins
+	}
+	var changes []InstanceChange
+	seen := make(map[string]bool, len(next))
+	for _, ins := range next {
+		id := ins.Id()
+		seen[id] = true
+		old, ok := byID[id]
+		if !ok {
+			changes = append(changes, InstanceChange{InstanceAdded, ins, nil})
+			continue
+		}
+		if old.Status != ins.Status {
+			changes = append(changes, InstanceChange{InstanceStatusChanged, ins, old})
+		}
+		if !reflect.DeepEqual(old.Metadata.parsed, ins.Metadata.parsed) {
+			changes = append(changes, InstanceChange{InstanceMetadataChanged, ins, old})
+		}
+		if addressChanged(old, ins) {
+			changes = append(changes, InstanceChange{InstanceAddressChanged, ins, old})
+		}
+	}
+	for _, ins := range prev {
+		if !seen[ins.Id()] {
+			changes = append(changes, InstanceChange{InstanceRemoved, ins, nil})
+		}
+	}
+	return changes
+}
+
+// addressChanged reports whether the instance's host name, IP address, or ports differ between
+// the two snapshots, such that clients must now reach it elsewhere.
+func addressChanged(old, ins *Instance) bool {
+	return old.HostName != ins.HostName ||
+		old.IPAddr != ins.IPAddr ||
+		old.Port != ins.Port || old.PortEnabled != ins.PortEnabled ||
+		old.SecurePort != ins.SecurePort || old.SecurePortEnabled != ins.SecurePortEnabled
+}
+
+// instanceChangeNotifier tracks the most recently acquired set of instances for a source,
+// notifying subscribers of the changes between each successive set.
+type instanceChangeNotifier struct {
+	// m serializes both updates and deliveries to subscribers, so that each subscriber sees the
+	// changes in order.
+	m    sync.Mutex
+	last []*Instance
+	subs map[int]func([]InstanceChange)
+	next int
+}
+
+// publish notes a newly acquired set of instances, calling store to make the set available to
+// the source's readers, and then notifying subscribers of any changes since the last such set.
+func (n *instanceChangeNotifier) publish(instances []*Instance, store func()) {
+	n.m.Lock()
+	defer n.m.Unlock()
+	// Parse the instances' metadata for comparison before any readers can see them.
+	for _, ins := range instances {
+		if ins.Metadata.parsed == nil {
+			ins.Metadata.parse()
+		}
+	}
+	changes := diffInstances(n.last, instances)
+	n.last = instances
+	store()
+	if len(changes) == 0 {
+		return
+	}
+	for _, f := range n.subs {
+		f(changes)
+	}
+}
+
+func (n *instanceChangeNotifier) subscribe(f func([]InstanceChange)) func() {
+	n.m.Lock()
+	defer n.m.Unlock()
+	if n.subs == nil {
+		n.subs = make(map[int]func([]InstanceChange))
+	}
+	id := n.next
+	n.next++
+	n.subs[id] = f
+	if changes := diffInstances(nil, n.last); len(changes) > 0 {
+		f(changes)
+	}
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			n.m.Lock()
+			defer n.m.Unlock()
+			delete(n.subs, id)
+		})
+	}
+}
+
+// Subscribe arranges for f to be called with the changes between each successive set of instances
+// the source acquires, for as long as it continues to update its set. If the source has already
+// acquired a set of instances, f is first called with each of them as added.
+//
+// Changes are computed against the most recent set acquired successfully, so a failed update
+// attempt yields no changes. The source calls f from one goroutine at a time, in order, and waits
+// for it to return before moving on, so f should return promptly and must not call Subscribe or
+// the returned function.
+//
+// Subscribe returns a function that cancels the subscription. It is safe to call more than once.
+func (s *InstanceSetSource) Subscribe(f func(changes []InstanceChange)) (unsubscribe func()) {
+	if s == nil {
+		return func() {}
+	}
+	return s.notifier.subscribe(f)
+}
+
+// Subscribe arranges for f to be called with the changes between the instances in each successive
+// copy of the application the source acquires, for as long as it continues to update its
+// application. If the source has already acquired the application, f is first called with each of
+// its instances as added.
+//
+// Changes are computed against the most recent copy acquired successfully, so a failed update
+// attempt yields no changes. The source calls f from one goroutine at a time, in order, and waits
+// for it to return before moving on, so f should return promptly and must not call Subscribe or
+// the returned function.
+//
+// Subscribe returns a function that cancels the subscription. It is safe to call more than once.
+func (s *AppSource) Subscribe(f func(changes []InstanceChange)) (unsubscribe func()) {
+	if s == nil {
+		return func() {}
+	}
+	return s.notifier.subscribe(f)
+}
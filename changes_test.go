@@ -0,0 +1,183 @@
+package fargo
+
+// MIT Licensed (see README.md) - Copyright (c) 2013 Hudl <@Hudl>
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func instanceWith(id string, status StatusType, metadata map[string]string) *Instance {
+	ins := &Instance{InstanceId: id, App: "TESTAPP", Status: status}
+	for k, v := range metadata {
+		ins.SetMetadataString(k, v)
+	}
+	return ins
+}
+
+func changeTypes(changes []InstanceChange) []string {
+	types := make([]string, len(changes))
+	for i, c := range changes {
+		types[i] = string(c.Type) + " " + c.Instance.Id()
+	}
+	return types
+}
+
+func TestDiffInstances(t *testing.T) {
+	Convey("Given two successive sets of instances", t, func() {
+		prev := []*Instance{
+			instanceWith("i-1", UP, nil),
+			instanceWith("i-2", UP, map[string]string{"weight": "1"}),
+			instanceWith("i-3", UP, nil),
+			instanceWith("i-4", UP, map[string]string{"weight": "1"}),
+		}
+		next := []*Instance{
+			instanceWith("i-5", STARTING, nil),
+			instanceWith("i-4", DOWN, map[string]string{"weight": "2"}),
+			instanceWith("i-2", UP, map[string]string{"weight": "2"}),
+			instanceWith("i-1", OUTOFSERVICE, nil),
+		}
+
+		Convey("the changes between them are keyed by instance ID", func() {
+			changes := diffInstances(prev, next)
+			So(changeTypes(changes), ShouldResemble, []string{
+				"ADDED i-5",
+				"STATUS_CHANGED i-4",
+				"METADATA_CHANGED i-4",
+				"METADATA_CHANGED i-2",
+				"STATUS_CHANGED i-1",
+				"REMOVED i-3",
+			})
+			So(changes[0].Previous, ShouldBeNil)
+			So(changes[1].Previous, ShouldEqual, prev[3])
+			So(changes[5].Instance, ShouldEqual, prev[2])
+		})
+
+		Convey("identical sets yield no changes", func() {
+			So(diffInstances(prev, prev), ShouldBeEmpty)
+		})
+
+		Convey("an instance that moves to another address or port is changed", func() {
+			moved := *prev[0]
+			moved.HostName, moved.IPAddr = "i-1.example.com", "10.0.0.9"
+			So(changeTypes(diffInstances(prev[:1], []*Instance{&moved})), ShouldResemble, []string{"ADDRESS_CHANGED i-1"})
+			moved = *prev[0]
+			moved.Port = 8081
+			So(changeTypes(diffInstances(prev[:1], []*Instance{&moved})), ShouldResemble, []string{"ADDRESS_CHANGED i-1"})
+			moved = *prev[0]
+			moved.SecurePortEnabled = true
+			So(changeTypes(diffInstances(prev[:1], []*Instance{&moved})), ShouldResemble, []string{"ADDRESS_CHANGED i-1"})
+		})
+	})
+}
+
+// subscriber collects the changes delivered to it.
+type subscriber struct {
+	m       sync.Mutex
+	changes [][]string
+}
+
+func (s *subscriber) receive(changes []InstanceChange) {
+	s.m.Lock()
+	defer s.m.Unlock()
+	s.changes = append(s.changes, changeTypes(changes))
+}
+
+func (s *subscriber) received() [][]string {
+	s.m.Lock()
+	defer s.m.Unlock()
+	return s.changes
+}
+
+func TestInstanceSetSourceSubscription(t *testing.T) {
+	Convey("Given an instance set source polling a changing set of instances", t, func() {
+		results := make(chan []*Instance)
+		failures := make(chan error)
+		produce := func() ([]*Instance, error) {
+			select {
+			case instances := <-results:
+				return instances, nil
+			case err := <-failures:
+				return nil, err
+			}
+		}
+		// Deliver the first set directly, so that the source awaits it.
+		go func() { results <- []*Instance{instanceWith("i-1", UP, nil)} }()
+		e := EurekaConnection{PollInterval: time.Millisecond}
+		s := e.newInstanceSetSourceFor(produce, true)
+		defer s.Stop()
+
+		Convey("a subscriber first receives the current instances as added", func() {
+			sub := &subscriber{}
+			unsubscribe := s.Subscribe(sub.receive)
+			So(sub.received(), ShouldResemble, [][]string{{"ADDED i-1"}})
+
+			Convey("and then receives the changes from each poll", func() {
+				results <- []*Instance{instanceWith("i-1", DOWN, nil), instanceWith("i-2", UP, nil)}
+				failures <- errors.New("unavailable")
+				results <- []*Instance{instanceWith("i-2", UP, nil)}
+				// Wait for the last poll to be consumed.
+				results <- []*Instance{instanceWith("i-2", UP, nil)}
+				So(sub.received(), ShouldResemble, [][]string{
+					{"ADDED i-1"},
+					{"STATUS_CHANGED i-1", "ADDED i-2"},
+					{"REMOVED i-1"},
+				})
+
+				Convey("until it unsubscribes", func() {
+					unsubscribe()
+					unsubscribe()
+					results <- []*Instance{}
+					results <- []*Instance{}
+					So(sub.received(), ShouldHaveLength, 3)
+				})
+			})
+		})
+	})
+}
+
+func TestAppSourceSubscription(t *testing.T) {
+	Convey("Given an app source polling an application whose instances change", t, func() {
+		var m sync.Mutex
+		body := "<application><name>A</name>" + instanceXML("a1", UP, "") + "</application>"
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			m.Lock()
+			defer m.Unlock()
+			fmt.Fprint(w, body)
+		}))
+		defer server.Close()
+		e := NewConn(server.URL)
+		e.HTTPClient = &http.Client{Transport: http.DefaultTransport}
+		e.Logger = NoopLogger{}
+		e.PollInterval = time.Millisecond
+		s := e.NewAppSource("A", true)
+		defer s.Stop()
+
+		Convey("a subscriber receives the changes to its instances", func() {
+			sub := &subscriber{}
+			defer s.Subscribe(sub.receive)()
+			m.Lock()
+			body = "<application><name>A</name>" + instanceXML("a2", UP, "") + "</application>"
+			m.Unlock()
+			deadline := time.Now().Add(5 * time.Second)
+			for len(sub.received()) < 2 && time.Now().Before(deadline) {
+				time.Sleep(time.Millisecond)
+			}
+			So(sub.received(), ShouldResemble, [][]string{{"ADDED a1"}, {"ADDED a2", "REMOVED a1"}})
+		})
+	})
+
+	Convey("A nil app source delivers no changes", t, func() {
+		var s *AppSource
+		sub := &subscriber{}
+		s.Subscribe(sub.receive)()
+		So(sub.received(), ShouldBeEmpty)
+	})
+}
@@ -3,6 +3,8 @@ package fargo
 // MIT Licensed (see README.md) - Copyright (c) 2013 Hudl <@Hudl>
 
 import (
+	"time"
+
 	"gopkg.in/gcfg.v1"
 )
 
@@ -10,44 +12,81 @@ import (
 type Config struct {
 	AWS    aws
 	Eureka eureka
+	// Zone holds the service URLs for each zone, as in [zone "us-east-1a"] sections
+	Zone map[string]*zone
+	// Instance describes the instance this client registers with Eureka
+	Instance instance
 }
 
 type aws struct {
 	AccessKeyID     string
 	SecretAccessKey string
-	// zones if running in AWS specifies as [us-east-1a, us-east-1b]
+	// zones if running in AWS specifies as [us-east-1a, us-east-1b], the client's own first
 	AvailabilityZones []string
 	// service urls for individual zones, ex [eureka1.east1a.my.com, eureka2.east1a.my.com]
+	// Deprecated: use [zone "us-east-1a"] sections instead
 	ServiceUrlsEast1a []string
 	ServiceUrlsEast1b []string
 	ServiceUrlsEast1c []string
 	ServiceUrlsEast1d []string
 	ServiceUrlsEast1e []string
-	Region            string // unused. Currently only set up for us-east-1
+	Region            string // default "", using the EC2 instance's own region for DNS discovery
+}
+
+type zone struct {
+	ServiceUrls []string // default []
 }
 
 type eureka struct {
 	InTheCloud            bool     // default false
 	ConnectTimeoutSeconds int      // default 10s
 	UseDNSForServiceUrls  bool     // default false
+	UseSRVForServiceUrls  bool     // default false, discovering via the SRV records at DNSDiscoveryZone
 	DNSDiscoveryZone      string   // default ""
 	ServerDNSName         string   // default ""
+	DNSServers            []string // default [], using the name servers in /etc/resolv.conf
 	ServiceUrls           []string // default []
 	ServerPort            int      // default 7001
 	ServerURLBase         string   // default "eureka/v2"
 	PollIntervalSeconds   int      // default 30
-	EnableDelta           bool     // TODO: Support querying for deltas
+	EnableDelta           bool     // default false
 	PreferSameZone        bool     // default false
 	RegisterWithEureka    bool     // default false
 	Retries               int      // default 3
+	TLSCAFile             string   // default "", trusting the host's root CA set
+	TLSCertFile           string   // default "", presenting no client certificate
+	TLSKeyFile            string   // default ""
+	TLSServerName         string   // default "", verifying the host name in each service URL
 }
 
-// ReadConfig from a file location. Minimal error handling. Just bails and passes up
-// an error if the file isn't found
+type instance struct {
+	App                         string // default ""
+	InstanceID                  string // default "", identifying the instance by its host name
+	HostName                    string // default "", using the name the host reports
+	IPAddress                   string // default "", detecting the host's address
+	Port                        int    // default 0, leaving the insecure port disabled
+	SecurePort                  int    // default 0, leaving the secure port disabled
+	VIPAddress                  string // default "", using the application name
+	SecureVIPAddress            string // default "", using the application name
+	HomePagePath                string // default "/"
+	StatusPagePath              string // default "/status"
+	HealthCheckPath             string // default "/healthcheck"
+	LeaseRenewalIntervalSeconds int    // default 30
+	LeaseDurationSeconds        int    // default 90
+	// Metadata can't be set in gcfg files, only in properties and YAML files
+	Metadata map[string]string
+}
+
+// ReadConfig from a file location, overlaid with any settings from the environment (see
+// OverlayEnvironment). Minimal error handling. Just bails and passes up an error if the file isn't
+// found
 func ReadConfig(loc string) (conf Config, err error) {
 	err = gcfg.ReadFileInto(&conf, loc)
+	if err == nil {
+		err = conf.OverlayEnvironment()
+	}
 	if err != nil {
-		log.Criticalf("Unable to read config file Error: %s", err.Error())
+		log().Error("Unable to read config file", "file", loc, "error", err)
 		return conf, err
 	}
 	conf.fillDefaults()
@@ -72,3 +111,80 @@ func (c *Config) fillDefaults() {
 		c.Eureka.ServerURLBase = "eureka/v2"
 	}
 }
+
+// serviceUrlsByZone merges the service URLs from the zone sections with those from the legacy
+// per-zone fields in the aws section.
+func (c *Config) serviceUrlsByZone() map[string][]string {
+	m := make(map[string][]string)
+	for name, z := range c.Zone {
+		if z != nil && len(z.ServiceUrls) > 0 {
+			m[name] = z.ServiceUrls
+		}
+	}
+	for name, urls := range map[string][]string{
+		"us-east-1a": c.AWS.ServiceUrlsEast1a,
+		"us-east-1b": c.AWS.ServiceUrlsEast1b,
+		"us-east-1c": c.AWS.ServiceUrlsEast1c,
+		"us-east-1d": c.AWS.ServiceUrlsEast1d,
+		"us-east-1e": c.AWS.ServiceUrlsEast1e,
+	} {
+		if _, ok := m[name]; !ok && len(urls) > 0 {
+			m[name] = urls
+		}
+	}
+	if len(m) == 0 {
+		return nil
+	}
+	return m
+}
+
+// NewInstanceBuilder returns an InstanceBuilder for the instance described by the config's
+// instance section.
+func (c *Config) NewInstanceBuilder() *InstanceBuilder {
+	in := c.Instance
+	b := NewInstanceBuilder(in.App)
+	if len(in.InstanceID) > 0 {
+		b.WithInstanceID(in.InstanceID)
+	}
+	if len(in.HostName) > 0 {
+		b.WithHostName(in.HostName)
+	}
+	if len(in.IPAddress) > 0 {
+		b.WithIPAddress(in.IPAddress)
+	}
+	if in.Port > 0 {
+		b.WithPort(in.Port)
+	}
+	if in.SecurePort > 0 {
+		b.WithSecurePort(in.SecurePort)
+	}
+	if len(in.VIPAddress) > 0 {
+		b.WithVIPAddress(in.VIPAddress)
+	}
+	if len(in.SecureVIPAddress) > 0 {
+		b.WithSecureVIPAddress(in.SecureVIPAddress)
+	}
+	if len(in.HomePagePath) > 0 {
+		b.WithHomePagePath(in.HomePagePath)
+	}
+	if len(in.StatusPagePath) > 0 {
+		b.WithStatusPagePath(in.StatusPagePath)
+	}
+	if len(in.HealthCheckPath) > 0 {
+		b.WithHealthCheckPath(in.HealthCheckPath)
+	}
+	if in.LeaseRenewalIntervalSeconds > 0 || in.LeaseDurationSeconds > 0 {
+		renewal, duration := defaultRenewalInterval, defaultLeaseDuration
+		if in.LeaseRenewalIntervalSeconds > 0 {
+			renewal = time.Duration(in.LeaseRenewalIntervalSeconds) * time.Second
+		}
+		if in.LeaseDurationSeconds > 0 {
+			duration = time.Duration(in.LeaseDurationSeconds) * time.Second
+		}
+		b.WithLease(renewal, duration)
+	}
+	for k, v := range in.Metadata {
+		b.WithMetadata(k, v)
+	}
+	return b
+}
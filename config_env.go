@@ -0,0 +1,142 @@
+package fargo
+
+// MIT Licensed (see README.md) - Copyright (c) 2013 Hudl <@Hudl>
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// EnvConfigPrefix begins the names of the environment variables that override a Config's fields.
+const EnvConfigPrefix = "FARGO_"
+
+// ReadConfigFromEnvironment returns a config drawn only from the environment variables described
+// by OverlayEnvironment, with defaults filled in for the fields they leave unset.
+func ReadConfigFromEnvironment() (conf Config, err error) {
+	if err = conf.OverlayEnvironment(); err != nil {
+		log().Error("Unable to read config from environment", "error", err)
+		return conf, err
+	}
+	conf.fillDefaults()
+	return conf, nil
+}
+
+// OverlayEnvironment sets the config's fields per the environment variables named for them.
+// ReadConfig, ReadPropertiesConfig, and ReadYAMLConfig call it once they've read their files,
+// before filling in defaults, such that the environment takes precedence over the files.
+//
+// Each field in the aws, eureka, and instance sections is named by the prefix "FARGO_", followed
+// by the section's and the field's names in upper case, separated by an underscore, as in
+// FARGO_EUREKA_POLLINTERVALSECONDS. Fields that hold lists take comma-separated values, as in
+// FARGO_EUREKA_SERVICEURLS=http://eureka1:8080/eureka/v2,http://eureka2:8080/eureka/v2. The
+// service URLs for a zone are named FARGO_ZONE_<zone>_SERVICEURLS, where the zone's name is
+// written in upper case with underscores in place of dashes, as in
+// FARGO_ZONE_US_EAST_1A_SERVICEURLS for zone "us-east-1a". The instance's metadata items are named
+// FARGO_INSTANCE_METADATA_<key>, with the key written as is.
+//
+// It ignores variables that are set but empty. Should any variable hold a value unfit for its
+// field, or any variable beginning with "FARGO_" name no field at all, as when misspelled, it
+// returns an error naming each such variable, having applied the others.
+func (c *Config) OverlayEnvironment() error {
+	return c.overlayEnvironment(os.Environ())
+}
+
+func (c *Config) overlayEnvironment(environ []string) error {
+	vars := make(map[string]string)
+	for _, kv := range environ {
+		i := strings.IndexByte(kv, '=')
+		if i < 0 || !strings.HasPrefix(kv, EnvConfigPrefix) || i == len(kv)-1 {
+			continue
+		}
+		vars[kv[:i]] = kv[i+1:]
+	}
+	var problems []string
+	fail := func(name, value string, err error) {
+		problems = append(problems, fmt.Sprintf("invalid value %q for environment variable %s: %v", value, name, err))
+	}
+
+	applied := make(map[string]bool, len(vars))
+	sections := reflect.ValueOf(c).Elem()
+	for i := 0; i < sections.NumField(); i++ {
+		section := sections.Field(i)
+		if section.Kind() != reflect.Struct {
+			continue
+		}
+		prefix := EnvConfigPrefix + strings.ToUpper(sections.Type().Field(i).Name) + "_"
+		for j := 0; j < section.NumField(); j++ {
+			name := prefix + strings.ToUpper(section.Type().Field(j).Name)
+			value, ok := vars[name]
+			if !ok {
+				continue
+			}
+			applied[name] = true
+			if err := setEnvField(section.Field(j), value); err != nil {
+				fail(name, value, err)
+			}
+		}
+	}
+
+	names := make([]string, 0, len(vars))
+	for name := range vars {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	const zonePrefix, zoneSuffix = EnvConfigPrefix + "ZONE_", "_SERVICEURLS"
+	const metadataPrefix = EnvConfigPrefix + "INSTANCE_METADATA_"
+	for _, name := range names {
+		switch {
+		case applied[name]:
+		case strings.HasPrefix(name, zonePrefix) && strings.HasSuffix(name, zoneSuffix) && len(name) > len(zonePrefix)+len(zoneSuffix):
+			z := strings.ToLower(strings.Replace(name[len(zonePrefix):len(name)-len(zoneSuffix)], "_", "-", -1))
+			if c.Zone == nil {
+				c.Zone = make(map[string]*zone)
+			}
+			c.Zone[z] = &zone{ServiceUrls: splitList(vars[name])}
+		case strings.HasPrefix(name, metadataPrefix) && len(name) > len(metadataPrefix):
+			if c.Instance.Metadata == nil {
+				c.Instance.Metadata = make(map[string]string)
+			}
+			c.Instance.Metadata[name[len(metadataPrefix):]] = vars[name]
+		default:
+			problems = append(problems, fmt.Sprintf("unknown environment variable %s", name))
+		}
+	}
+
+	if len(problems) > 0 {
+		return errors.New(strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// setEnvField sets a config field of a type that an environment variable can express.
+func setEnvField(field reflect.Value, value string) error {
+	switch field.Kind() {
+	case reflect.String:
+		field.SetString(value)
+	case reflect.Int:
+		n, err := strconv.Atoi(strings.TrimSpace(value))
+		if err != nil {
+			return errors.New("not an integer")
+		}
+		field.SetInt(int64(n))
+	case reflect.Bool:
+		b, err := strconv.ParseBool(strings.TrimSpace(value))
+		if err != nil {
+			return errors.New("not a boolean")
+		}
+		field.SetBool(b)
+	case reflect.Slice:
+		if field.Type().Elem().Kind() != reflect.String {
+			return fmt.Errorf("unsupported field type %s", field.Type())
+		}
+		field.Set(reflect.ValueOf(splitList(value)))
+	default:
+		return fmt.Errorf("unsupported field type %s", field.Type())
+	}
+	return nil
+}
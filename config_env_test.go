@@ -0,0 +1,75 @@
+package fargo
+
+// MIT Licensed (see README.md) - Copyright (c) 2013 Hudl <@Hudl>
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestOverlayEnvironment(t *testing.T) {
+	Convey("Given a config read from a file", t, func() {
+		c := Config{}
+		c.Eureka.ServiceUrls = []string{"http://file:8080/eureka/v2"}
+		c.Eureka.ServerPort = 8080
+		c.AWS.Region = "us-east-1"
+
+		Convey("environment variables override its fields", func() {
+			err := c.overlayEnvironment([]string{
+				"PATH=/usr/bin",
+				"FARGO_EUREKA_SERVICEURLS=http://env1:8080/eureka/v2, http://env2:8080/eureka/v2",
+				"FARGO_EUREKA_POLLINTERVALSECONDS=15",
+				"FARGO_EUREKA_PREFERSAMEZONE=true",
+				"FARGO_AWS_REGION=",
+				"FARGO_AWS_AVAILABILITYZONES=us-west-2a,us-west-2b",
+				"FARGO_ZONE_US_WEST_2A_SERVICEURLS=http://west2a:8080/eureka/v2",
+				"FARGO_INSTANCE_APP=testapp",
+				"FARGO_INSTANCE_PORT=9090",
+				"FARGO_INSTANCE_METADATA_version=1.2.3",
+			})
+			So(err, ShouldBeNil)
+			So(c.Eureka.ServiceUrls, ShouldResemble, []string{"http://env1:8080/eureka/v2", "http://env2:8080/eureka/v2"})
+			So(c.Eureka.PollIntervalSeconds, ShouldEqual, 15)
+			So(c.Eureka.PreferSameZone, ShouldBeTrue)
+			So(c.Eureka.ServerPort, ShouldEqual, 8080)
+			So(c.AWS.Region, ShouldEqual, "us-east-1")
+			So(c.AWS.AvailabilityZones, ShouldResemble, []string{"us-west-2a", "us-west-2b"})
+			So(c.Zone["us-west-2a"].ServiceUrls, ShouldResemble, []string{"http://west2a:8080/eureka/v2"})
+			So(c.Instance.App, ShouldEqual, "testapp")
+			So(c.Instance.Port, ShouldEqual, 9090)
+			So(c.Instance.Metadata, ShouldResemble, map[string]string{"version": "1.2.3"})
+
+			Convey("before defaults are filled in", func() {
+				c.fillDefaults()
+				So(c.Eureka.PollIntervalSeconds, ShouldEqual, 15)
+				So(c.Eureka.Retries, ShouldEqual, 3)
+			})
+		})
+
+		Convey("malformed values are reported by variable", func() {
+			err := c.overlayEnvironment([]string{
+				"FARGO_EUREKA_POLLINTERVALSECONDS=30s",
+				"FARGO_EUREKA_ENABLEDELTA=sometimes",
+			})
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, `invalid value "30s" for environment variable FARGO_EUREKA_POLLINTERVALSECONDS: not an integer`)
+			So(err.Error(), ShouldContainSubstring, "FARGO_EUREKA_ENABLEDELTA")
+		})
+
+		Convey("variables that name no field are reported", func() {
+			err := c.overlayEnvironment([]string{
+				"FARGO_EUREKA_POLLINTERVALSECS=15",
+				"FARGO_EUREKA_RETRIES=5",
+				"FARGO_INSTANCE_METADATA=version",
+				"FARGO_ZONE_US_WEST_2A=http://west2a:8080/eureka/v2",
+			})
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "unknown environment variable FARGO_EUREKA_POLLINTERVALSECS")
+			So(err.Error(), ShouldContainSubstring, "unknown environment variable FARGO_ZONE_US_WEST_2A")
+			So(err.Error(), ShouldContainSubstring, "FARGO_INSTANCE_METADATA: unsupported field type")
+			So(err.Error(), ShouldNotContainSubstring, "FARGO_EUREKA_RETRIES")
+			So(c.Eureka.Retries, ShouldEqual, 5)
+		})
+	})
+}
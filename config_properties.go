@@ -0,0 +1,369 @@
+package fargo
+
+// MIT Licensed (see README.md) - Copyright (c) 2013 Hudl <@Hudl>
+
+import (
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// negated marks a config field that holds the opposite of a boolean property's value.
+type negated struct {
+	b *bool
+}
+
+// configProperties maps the names of the properties that Eureka's Java clients understand to the
+// fields of a Config that they set. Each name is in canonical form, in lower case and without
+// dashes or underscores. The names with the eureka.client and eureka.instance prefixes are those
+// used by Spring Cloud Netflix; the others are those used by Eureka's own Java client.
+var configProperties = map[string]func(c *Config) interface{}{
+	"eureka.client.region":                            func(c *Config) interface{} { return &c.AWS.Region },
+	"eureka.region":                                   func(c *Config) interface{} { return &c.AWS.Region },
+	"eureka.client.registerwitheureka":                func(c *Config) interface{} { return &c.Eureka.RegisterWithEureka },
+	"eureka.registration.enabled":                     func(c *Config) interface{} { return &c.Eureka.RegisterWithEureka },
+	"eureka.client.registryfetchintervalseconds":      func(c *Config) interface{} { return &c.Eureka.PollIntervalSeconds },
+	"eureka.client.refresh.interval":                  func(c *Config) interface{} { return &c.Eureka.PollIntervalSeconds },
+	"eureka.client.eurekaserverconnecttimeoutseconds": func(c *Config) interface{} { return &c.Eureka.ConnectTimeoutSeconds },
+	"eureka.client.prefersamezoneeureka":              func(c *Config) interface{} { return &c.Eureka.PreferSameZone },
+	"eureka.prefersamezone":                           func(c *Config) interface{} { return &c.Eureka.PreferSameZone },
+	"eureka.client.usednsforfetchingserviceurls":      func(c *Config) interface{} { return &c.Eureka.UseDNSForServiceUrls },
+	"eureka.shouldusedns":                             func(c *Config) interface{} { return &c.Eureka.UseDNSForServiceUrls },
+	"eureka.client.eurekaserverdnsname":               func(c *Config) interface{} { return &c.Eureka.DNSDiscoveryZone },
+	"eureka.eurekaserver.domainname":                  func(c *Config) interface{} { return &c.Eureka.DNSDiscoveryZone },
+	"eureka.client.eurekaserverport":                  func(c *Config) interface{} { return &c.Eureka.ServerPort },
+	"eureka.eurekaserver.port":                        func(c *Config) interface{} { return &c.Eureka.ServerPort },
+	"eureka.client.eurekaserverurlcontext":            func(c *Config) interface{} { return &c.Eureka.ServerURLBase },
+	"eureka.eurekaserver.context":                     func(c *Config) interface{} { return &c.Eureka.ServerURLBase },
+	"eureka.client.disabledelta":                      func(c *Config) interface{} { return negated{&c.Eureka.EnableDelta} },
+	"eureka.disabledelta":                             func(c *Config) interface{} { return negated{&c.Eureka.EnableDelta} },
+
+	"eureka.instance.appname":                          func(c *Config) interface{} { return &c.Instance.App },
+	"eureka.name":                                      func(c *Config) interface{} { return &c.Instance.App },
+	"eureka.instance.instanceid":                       func(c *Config) interface{} { return &c.Instance.InstanceID },
+	"eureka.instance.hostname":                         func(c *Config) interface{} { return &c.Instance.HostName },
+	"eureka.instance.ipaddress":                        func(c *Config) interface{} { return &c.Instance.IPAddress },
+	"eureka.instance.nonsecureport":                    func(c *Config) interface{} { return &c.Instance.Port },
+	"eureka.port":                                      func(c *Config) interface{} { return &c.Instance.Port },
+	"eureka.instance.secureport":                       func(c *Config) interface{} { return &c.Instance.SecurePort },
+	"eureka.secureport":                                func(c *Config) interface{} { return &c.Instance.SecurePort },
+	"eureka.instance.virtualhostname":                  func(c *Config) interface{} { return &c.Instance.VIPAddress },
+	"eureka.vipaddress":                                func(c *Config) interface{} { return &c.Instance.VIPAddress },
+	"eureka.instance.securevirtualhostname":            func(c *Config) interface{} { return &c.Instance.SecureVIPAddress },
+	"eureka.securevipaddress":                          func(c *Config) interface{} { return &c.Instance.SecureVIPAddress },
+	"eureka.instance.homepageurlpath":                  func(c *Config) interface{} { return &c.Instance.HomePagePath },
+	"eureka.homepageurlpath":                           func(c *Config) interface{} { return &c.Instance.HomePagePath },
+	"eureka.instance.statuspageurlpath":                func(c *Config) interface{} { return &c.Instance.StatusPagePath },
+	"eureka.statuspageurlpath":                         func(c *Config) interface{} { return &c.Instance.StatusPagePath },
+	"eureka.instance.healthcheckurlpath":               func(c *Config) interface{} { return &c.Instance.HealthCheckPath },
+	"eureka.healthcheckurlpath":                        func(c *Config) interface{} { return &c.Instance.HealthCheckPath },
+	"eureka.instance.leaserenewalintervalinseconds":    func(c *Config) interface{} { return &c.Instance.LeaseRenewalIntervalSeconds },
+	"eureka.lease.renewalinterval":                     func(c *Config) interface{} { return &c.Instance.LeaseRenewalIntervalSeconds },
+	"eureka.instance.leaseexpirationdurationinseconds": func(c *Config) interface{} { return &c.Instance.LeaseDurationSeconds },
+	"eureka.lease.duration":                            func(c *Config) interface{} { return &c.Instance.LeaseDurationSeconds },
+}
+
+// ReadPropertiesConfig reads a config from a Java properties file, such as one that configures a
+// Java service's Eureka client. It understands the properties used by Spring Cloud Netflix, such
+// as eureka.client.serviceUrl.defaultZone, along with those used by Eureka's own Java client, such
+// as eureka.serviceUrl.default. Property names may be written in camel case or in kebab case, as
+// in eureka.client.service-url.default-zone. It ignores the properties it doesn't understand. Like
+// ReadConfig, it overlays the config with any settings from the environment.
+func ReadPropertiesConfig(loc string) (conf Config, err error) {
+	b, err := ioutil.ReadFile(loc)
+	if err != nil {
+		log().Error("Unable to read config file", "file", loc, "error", err)
+		return conf, err
+	}
+	props, err := parseProperties(string(b))
+	if err == nil {
+		err = conf.setProperties(props)
+	}
+	if err == nil {
+		err = conf.OverlayEnvironment()
+	}
+	if err != nil {
+		log().Error("Unable to read config file", "file", loc, "error", err)
+		return conf, err
+	}
+	conf.fillDefaults()
+	return conf, nil
+}
+
+// ReadYAMLConfig reads a config from a YAML file, such as a Spring Boot application.yml file. It
+// understands the same properties as ReadPropertiesConfig, nested as in:
+//
+//	eureka:
+//	  client:
+//	    serviceUrl:
+//	      defaultZone: http://eureka1:8080/eureka/v2,http://eureka2:8080/eureka/v2
+//
+// Lists may stand in for comma-separated values. Like ReadConfig, it overlays the config with any
+// settings from the environment.
+func ReadYAMLConfig(loc string) (conf Config, err error) {
+	b, err := ioutil.ReadFile(loc)
+	if err != nil {
+		log().Error("Unable to read config file", "file", loc, "error", err)
+		return conf, err
+	}
+	var doc interface{}
+	err = yaml.Unmarshal(b, &doc)
+	if err == nil {
+		props := make(map[string]string)
+		flattenYAML("", doc, props)
+		err = conf.setProperties(props)
+	}
+	if err == nil {
+		err = conf.OverlayEnvironment()
+	}
+	if err != nil {
+		log().Error("Unable to read config file", "file", loc, "error", err)
+		return conf, err
+	}
+	conf.fillDefaults()
+	return conf, nil
+}
+
+// flattenYAML records the scalar values in the given YAML document as properties, naming each by
+// the path of keys leading to it, joined by dots.
+func flattenYAML(prefix string, v interface{}, props map[string]string) {
+	switch v := v.(type) {
+	case map[interface{}]interface{}:
+		for k, value := range v {
+			name := fmt.Sprint(k)
+			if len(prefix) > 0 {
+				name = prefix + "." + name
+			}
+			flattenYAML(name, value, props)
+		}
+	case []interface{}:
+		items := make([]string, 0, len(v))
+		for _, item := range v {
+			items = append(items, fmt.Sprint(item))
+		}
+		props[prefix] = strings.Join(items, ",")
+	case nil:
+		props[prefix] = ""
+	default:
+		props[prefix] = fmt.Sprint(v)
+	}
+}
+
+// parseProperties parses the contents of a Java properties file.
+func parseProperties(s string) (map[string]string, error) {
+	props := make(map[string]string)
+	lines := strings.Split(strings.Replace(s, "\r\n", "\n", -1), "\n")
+	for i := 0; i < len(lines); i++ {
+		line := strings.TrimLeft(lines[i], " \t\f")
+		if len(line) == 0 || line[0] == '#' || line[0] == '!' {
+			continue
+		}
+		for continued(line) {
+			line = line[:len(line)-1]
+			if i+1 == len(lines) {
+				break
+			}
+			i++
+			line += strings.TrimLeft(lines[i], " \t\f")
+		}
+		key, value := splitProperty(line)
+		k, err := unescapeProperty(key)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", i+1, err)
+		}
+		v, err := unescapeProperty(value)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", i+1, err)
+		}
+		props[k] = v
+	}
+	return props, nil
+}
+
+// continued reports whether the given line ends with an unescaped backslash, continuing it onto
+// the next line.
+func continued(line string) bool {
+	n := 0
+	for i := len(line) - 1; i >= 0 && line[i] == '\\'; i-- {
+		n++
+	}
+	return n%2 == 1
+}
+
+// splitProperty splits a property's line into its key and value, which are separated by the first
+// unescaped '=', ':', or run of whitespace.
+func splitProperty(line string) (key, value string) {
+	end := len(line)
+	for i := 0; i < len(line); i++ {
+		if line[i] == '\\' {
+			i++
+			continue
+		}
+		if strings.IndexByte("=: \t\f", line[i]) >= 0 {
+			end = i
+			break
+		}
+	}
+	key, rest := line[:end], strings.TrimLeft(line[end:], " \t\f")
+	if len(rest) > 0 && (rest[0] == '=' || rest[0] == ':') {
+		rest = strings.TrimLeft(rest[1:], " \t\f")
+	}
+	return key, rest
+}
+
+// unescapeProperty resolves the escape sequences in a property's key or value.
+func unescapeProperty(s string) (string, error) {
+	if strings.IndexByte(s, '\\') < 0 {
+		return s, nil
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' || i+1 == len(s) {
+			b.WriteByte(s[i])
+			continue
+		}
+		i++
+		switch s[i] {
+		case 't':
+			b.WriteByte('\t')
+		case 'n':
+			b.WriteByte('\n')
+		case 'r':
+			b.WriteByte('\r')
+		case 'f':
+			b.WriteByte('\f')
+		case 'u':
+			if i+5 > len(s) {
+				return "", fmt.Errorf("malformed \\u escape in %q", s)
+			}
+			r, err := strconv.ParseUint(s[i+1:i+5], 16, 16)
+			if err != nil {
+				return "", fmt.Errorf("malformed \\u escape in %q", s)
+			}
+			b.WriteRune(rune(r))
+			i += 4
+		default:
+			b.WriteByte(s[i])
+		}
+	}
+	return b.String(), nil
+}
+
+// canonicalPropertyName puts a segment of a property's name in canonical form, in lower case and
+// without dashes or underscores, such that the camel case and kebab case spellings of the name
+// match.
+func canonicalPropertyName(name string) string {
+	return strings.ToLower(strings.NewReplacer("-", "", "_", "").Replace(name))
+}
+
+// splitList splits a comma-separated list of values, dropping empty ones.
+func splitList(s string) []string {
+	var items []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); len(item) > 0 {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// setProperties sets the config's fields per the given properties.
+func (c *Config) setProperties(props map[string]string) error {
+	names := make([]string, 0, len(props))
+	for name := range props {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	zonesByRegion := make(map[string][]string)
+	for _, name := range names {
+		value := strings.TrimSpace(props[name])
+		segments := strings.Split(name, ".")
+		canonical := make([]string, len(segments))
+		for i, s := range segments {
+			canonical[i] = canonicalPropertyName(s)
+		}
+		key := strings.Join(canonical, ".")
+		switch {
+		case strings.HasPrefix(key, "eureka.client.serviceurl.") || strings.HasPrefix(key, "eureka.serviceurl."):
+			i := 2
+			if canonical[1] == "client" {
+				i = 3
+			}
+			zoneName := strings.Join(segments[i:], ".")
+			if z := canonicalPropertyName(zoneName); z == "defaultzone" || z == "default" {
+				c.Eureka.ServiceUrls = splitList(value)
+				continue
+			}
+			if c.Zone == nil {
+				c.Zone = make(map[string]*zone)
+			}
+			c.Zone[zoneName] = &zone{ServiceUrls: splitList(value)}
+		case strings.HasPrefix(key, "eureka.client.availabilityzones."):
+			zonesByRegion[strings.Join(segments[3:], ".")] = splitList(value)
+		case len(canonical) == 3 && canonical[0] == "eureka" && canonical[2] == "availabilityzones":
+			zonesByRegion[segments[1]] = splitList(value)
+		case strings.HasPrefix(key, "eureka.instance.metadatamap.") || strings.HasPrefix(key, "eureka.metadata."):
+			i := 2
+			if canonical[1] == "instance" {
+				i = 3
+			}
+			if c.Instance.Metadata == nil {
+				c.Instance.Metadata = make(map[string]string)
+			}
+			c.Instance.Metadata[strings.Join(segments[i:], ".")] = value
+		default:
+			field, ok := configProperties[key]
+			if !ok {
+				continue
+			}
+			if err := setConfigField(field(c), value); err != nil {
+				return fmt.Errorf("invalid value %q for property %s: %v", value, name, err)
+			}
+		}
+	}
+	if len(c.Instance.App) == 0 {
+		for _, name := range names {
+			if canonicalPropertyName(name) == "spring.application.name" {
+				c.Instance.App = strings.TrimSpace(props[name])
+			}
+		}
+	}
+	region := c.AWS.Region
+	if len(region) == 0 {
+		region = "us-east-1"
+	}
+	if zones, ok := zonesByRegion[region]; ok {
+		c.AWS.AvailabilityZones = zones
+	}
+	return nil
+}
+
+func setConfigField(field interface{}, value string) error {
+	switch f := field.(type) {
+	case *string:
+		*f = value
+	case *int:
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return err
+		}
+		*f = n
+	case *bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return err
+		}
+		*f = b
+	case negated:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return err
+		}
+		*f.b = !b
+	}
+	return nil
+}
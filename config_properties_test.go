@@ -0,0 +1,56 @@
+package fargo
+
+// MIT Licensed (see README.md) - Copyright (c) 2013 Hudl <@Hudl>
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestParseProperties(t *testing.T) {
+	Convey("Properties files are parsed per the Java format", t, func() {
+		props, err := parseProperties(`# comment
+! another comment
+a=1
+b : 2
+c 3
+  d=spaced value
+e=first,\
+    second
+f\:g=escaped\tkey
+h=été
+i=
+`)
+		So(err, ShouldBeNil)
+		So(props, ShouldResemble, map[string]string{
+			"a":   "1",
+			"b":   "2",
+			"c":   "3",
+			"d":   "spaced value",
+			"e":   "first,second",
+			"f:g": "escaped\tkey",
+			"h":   "été",
+			"i":   "",
+		})
+
+		_, err = parseProperties(`a=\u00zz`)
+		So(err, ShouldNotBeNil)
+	})
+
+	Convey("Property names match regardless of case and dashes", t, func() {
+		var c Config
+		So(c.setProperties(map[string]string{
+			"EUREKA.CLIENT.EUREKA_SERVER_PORT":                "8080",
+			"eureka.client.serviceUrl.us-east-1a":             "http://a1, http://a2,",
+			"eureka.us-east-1.availabilityZones":              "us-east-1a",
+			"eureka.metadata.build.number":                    "42",
+			"eureka.client.use-dns-for-fetching-service-urls": "true",
+		}), ShouldBeNil)
+		So(c.Eureka.ServerPort, ShouldEqual, 8080)
+		So(c.Zone["us-east-1a"].ServiceUrls, ShouldResemble, []string{"http://a1", "http://a2"})
+		So(c.AWS.AvailabilityZones, ShouldResemble, []string{"us-east-1a"})
+		So(c.Instance.Metadata, ShouldResemble, map[string]string{"build.number": "42"})
+		So(c.Eureka.UseDNSForServiceUrls, ShouldBeTrue)
+	})
+}
@@ -3,7 +3,9 @@ package fargo
 // MIT Licensed (see README.md) - Copyright (c) 2013 Hudl <@Hudl>
 
 import (
+	"context"
 	"math/rand"
+	"os"
 	"sync"
 	"time"
 )
@@ -13,33 +15,45 @@ func init() {
 }
 
 // SelectServiceURL gets a eureka instance based on the connection's load
-// balancing scheme.
-// TODO: Make this not just pick a random one.
+// balancing scheme, picking at random among those servers in the most
+// preferred zone that haven't recently failed to handle requests.
 func (e *EurekaConnection) SelectServiceURL() string {
-	if e.discoveryTtl == nil {
-		e.discoveryTtl = make(chan struct{}, 1)
-	}
-	if e.DNSDiscovery && len(e.discoveryTtl) == 0 {
-		servers, ttl, err := discoverDNS(e.DiscoveryZone, e.ServicePort, e.ServerURLBase)
-		if err != nil {
-			return choice(e.ServiceUrls)
+	return e.selectServiceURL(context.Background())
+}
+
+func (e *EurekaConnection) selectServiceURL(ctx context.Context, exclude ...string) string {
+	tiers := e.serviceURLTiers()
+	var weights map[string]int
+	if e.DNSDiscovery {
+		// Fall back to the configured ServiceUrls until some servers have been discovered.
+		if discovered, w := e.dnsDiscovery().current(ctx); len(discovered) > 0 {
+			tiers, weights = discovered, w
 		}
-		e.discoveryTtl <- struct{}{}
-		time.AfterFunc(ttl, func() {
-			// At the end of the timeout, empty the channel so that the next
-			// SelectServiceURL call will refresh the DNS info
-			<-e.discoveryTtl
-		})
-		e.ServiceUrls = servers
 	}
-	return choice(e.ServiceUrls)
+	return choice(quarantine.available(tiers, exclude), weights)
 }
 
-func choice(options []string) string {
+// choice picks one of the given service URLs at random, favoring those with greater weights, if
+// any have weights.
+func choice(options []string, weights map[string]int) string {
 	if len(options) == 0 {
-		log.Fatal("There are no ServiceUrls to choose from, bailing out")
+		log().Error("There are no ServiceUrls to choose from, bailing out")
+		os.Exit(1)
+	}
+	total := 0
+	for _, o := range options {
+		total += weights[o]
+	}
+	if total == 0 {
+		return options[rand.Int()%len(options)]
+	}
+	n := rand.Intn(total)
+	for _, o := range options {
+		if n -= weights[o]; n < 0 {
+			return o
+		}
 	}
-	return options[rand.Int()%len(options)]
+	return options[len(options)-1]
 }
 
 // NewConnFromConfigFile sets up a connection object based on a config in
@@ -47,15 +61,24 @@ func choice(options []string) string {
 func NewConnFromConfigFile(location string) (c EurekaConnection, err error) {
 	cfg, err := ReadConfig(location)
 	if err != nil {
-		log.Errorf("Problem reading config %s error: %s", location, err.Error())
+		log().Error("Problem reading config", "file", location, "error", err)
 		return c, err
 	}
-	return NewConnFromConfig(cfg), nil
+	return newConnFromConfig(cfg)
 }
 
 // NewConnFromConfig will, given a Config struct, return a connection based on
-// those options
+// those options. If the TLS options in the config can't be honored, it logs the
+// problem and returns a connection that uses the default TLS configuration.
 func NewConnFromConfig(conf Config) (c EurekaConnection) {
+	c, err := newConnFromConfig(conf)
+	if err != nil {
+		log().Error("Problem configuring TLS, using defaults", "error", err)
+	}
+	return c
+}
+
+func newConnFromConfig(conf Config) (c EurekaConnection, err error) {
 	c.ServiceUrls = conf.Eureka.ServiceUrls
 	c.ServicePort = conf.Eureka.ServerPort
 	if len(c.ServiceUrls) == 0 && len(conf.Eureka.ServerDNSName) > 0 {
@@ -64,13 +87,38 @@ func NewConnFromConfig(conf Config) (c EurekaConnection) {
 	c.Timeout = time.Duration(conf.Eureka.ConnectTimeoutSeconds) * time.Second
 	c.PollInterval = time.Duration(conf.Eureka.PollIntervalSeconds) * time.Second
 	c.PreferSameZone = conf.Eureka.PreferSameZone
-	if conf.Eureka.UseDNSForServiceUrls {
-		log.Warning("UseDNSForServiceUrls is an experimental option")
+	c.EnableDelta = conf.Eureka.EnableDelta
+	c.Retries = conf.Eureka.Retries
+	c.AvailabilityZones = conf.AWS.AvailabilityZones
+	if len(c.AvailabilityZones) > 0 {
+		c.Zone = c.AvailabilityZones[0]
+	}
+	c.ServiceUrlsByZone = conf.serviceUrlsByZone()
+	if conf.Eureka.UseDNSForServiceUrls || conf.Eureka.UseSRVForServiceUrls {
+		log().Warn("UseDNSForServiceUrls is an experimental option")
 		c.DNSDiscovery = true
 		c.DiscoveryZone = conf.Eureka.DNSDiscoveryZone
 		c.ServerURLBase = conf.Eureka.ServerURLBase
+		c.DiscoveryRegion = conf.AWS.Region
+		c.DiscoverySRV = conf.Eureka.UseSRVForServiceUrls
+		if len(conf.Eureka.DNSServers) > 0 {
+			c.DNSResolver = DNSServers(conf.Eureka.DNSServers)
+		}
 	}
-	return c
+	tlsOptions := TLSOptions{
+		CAFile:     conf.Eureka.TLSCAFile,
+		CertFile:   conf.Eureka.TLSCertFile,
+		KeyFile:    conf.Eureka.TLSKeyFile,
+		ServerName: conf.Eureka.TLSServerName,
+	}
+	if !tlsOptions.isZero() {
+		tlsConfig, err := tlsOptions.TLSConfig()
+		if err != nil {
+			return c, err
+		}
+		c.HTTPClient = NewHTTPClient(tlsConfig)
+	}
+	return c, nil
 }
 
 // NewConn is a default connection with just a list of ServiceUrls. Most basic
@@ -86,10 +134,10 @@ func NewConn(address ...string) (e EurekaConnection) {
 func (e *EurekaConnection) UpdateApp(app *Application) {
 	go func() {
 		for {
-			log.Noticef("Updating app %s", app.Name)
+			e.logger().Info("Updating app", "app", app.Name)
 			err := e.readAppInto(app)
 			if err != nil {
-				log.Errorf("Failure updating %s in goroutine", app.Name)
+				e.logger().Error("Failure updating app in goroutine", "app", app.Name, "error", err)
 			}
 			<-time.After(time.Duration(e.PollInterval) * time.Second)
 		}
@@ -151,9 +199,10 @@ func (e *EurekaConnection) ScheduleAppUpdates(name string, await bool, done <-ch
 
 // An AppSource holds a periodically updated copy of a Eureka application.
 type AppSource struct {
-	m    sync.RWMutex
-	app  *Application
-	done chan<- struct{}
+	m        sync.RWMutex
+	app      *Application
+	done     chan<- struct{}
+	notifier instanceChangeNotifier
 }
 
 // NewAppSource returns a new AppSource that offers a periodically updated copy
@@ -172,15 +221,22 @@ func (e *EurekaConnection) NewAppSource(name string, await bool) *AppSource {
 	produce := func() (*Application, error) {
 		return e.GetApp(name)
 	}
-	if await {
-		if app, err := produce(); err == nil {
+	consume := func(app *Application, err error) {
+		store := func() {
+			s.m.Lock()
 			s.app = app
+			s.m.Unlock()
+		}
+		if err != nil || app == nil {
+			store()
+			return
 		}
+		s.notifier.publish(app.Instances, store)
 	}
-	consume := func(app *Application, err error) {
-		s.m.Lock()
-		s.app = app
-		s.m.Unlock()
+	if await {
+		if app, err := produce(); err == nil {
+			consume(app, nil)
+		}
 	}
 	go exchangeAppEvery(e.PollInterval, produce, consume, done)
 	return s
@@ -3,10 +3,13 @@ package fargo
 // MIT Licensed (see README.md) - Copyright (c) 2013 Hudl <@Hudl>
 
 import (
+	"context"
 	"fmt"
-	"github.com/cenkalti/backoff"
 	"github.com/franela/goreq"
 	"github.com/miekg/dns"
+	"net"
+	"sort"
+	"strings"
 	"time"
 )
 
@@ -14,71 +17,174 @@ const azURL = "http://169.254.169.254/latest/meta-data/placement/availability-zo
 
 var ErrNotInAWS = fmt.Errorf("Not in AWS")
 
-func discoverDNS(domain string, port int, urlBase string) (servers []string, ttl time.Duration, err error) {
-	r, _ := region()
+const defaultDiscoveryTTL = 120 * time.Second
+
+// A DNSResolver answers the DNS queries with which a connection discovers its Eureka servers.
+type DNSResolver interface {
+	Exchange(ctx context.Context, query *dns.Msg) (*dns.Msg, error)
+}
+
+// DNSServers is a DNSResolver that sends each query to the listed name servers in turn, moving
+// on to the next server whenever one fails to answer or reports a failure of its own. Each server
+// is given as "host:port", or as a bare host for those answering on port 53.
+type DNSServers []string
+
+// Exchange sends the given query to each name server in turn until one answers it.
+func (s DNSServers) Exchange(ctx context.Context, query *dns.Msg) (*dns.Msg, error) {
+	err := fmt.Errorf("no DNS servers to query")
+	for _, server := range s {
+		if _, _, splitErr := net.SplitHostPort(server); splitErr != nil {
+			server = net.JoinHostPort(server, "53")
+		}
+		var response *dns.Msg
+		response, err = dns.ExchangeContext(ctx, query, server)
+		if err == nil {
+			if response.Rcode != dns.RcodeServerFailure && response.Rcode != dns.RcodeRefused {
+				return response, nil
+			}
+			err = fmt.Errorf("DNS server %s responded with %s", server, dns.RcodeToString[response.Rcode])
+		}
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		log().Warn("DNS server failed to answer query", "server", server, "name", query.Question[0].Name, "error", err)
+	}
+	return nil, err
+}
+
+// resolvConf is a DNSResolver that sends queries to the name servers listed in a resolv.conf
+// file, reading the file anew for each query.
+type resolvConf string
+
+func (r resolvConf) Exchange(ctx context.Context, query *dns.Msg) (*dns.Msg, error) {
+	servers, err := findDnsServerAddrs(string(r))
+	if err != nil {
+		return nil, err
+	}
+	return servers.Exchange(ctx, query)
+}
+
+func (e *EurekaConnection) dnsResolver() DNSResolver {
+	if e.DNSResolver != nil {
+		return e.DNSResolver
+	}
+	return resolvConf("/etc/resolv.conf")
+}
+
+// discoverDNS discovers the connection's Eureka servers via the TXT records published for the
+// zones in its DiscoveryRegion, along with the least of the records' TTLs. Should it fail to look
+// up the servers in some of those zones, it returns those it found in the others along with the
+// error.
+func (e *EurekaConnection) discoverDNS(ctx context.Context) (servers []string, ttl time.Duration, err error) {
+	r := e.DiscoveryRegion
+	if len(r) == 0 {
+		r, _ = region()
+	}
+	resolver := e.dnsResolver()
 
 	// all DNS queries must use the FQDN
-	domain = "txt." + r + "." + dns.Fqdn(domain)
+	domain := r + "." + dns.Fqdn(e.DiscoveryZone)
 	if _, ok := dns.IsDomainName(domain); !ok {
 		err = fmt.Errorf("invalid domain name: '%s' is not a domain name", domain)
 		return
 	}
-	regionRecords, ttl, err := retryingFindTXT(domain)
-	if err != nil {
-		return
+	var zoneRecords []string
+	if len(e.DiscoveryZones) > 0 {
+		// Zones publish their servers under txt.<zone>.<DiscoveryZone>, without the region.
+		for _, z := range e.DiscoveryZones {
+			zoneRecords = append(zoneRecords, z+"."+dns.Fqdn(e.DiscoveryZone))
+		}
+	} else {
+		zoneRecords, ttl, err = findTXT(ctx, resolver, "txt."+domain)
+		if err != nil {
+			return
+		}
 	}
 
-	for _, az := range regionRecords {
-		instances, _, er := retryingFindTXT("txt." + dns.Fqdn(az))
+	for _, az := range zoneRecords {
+		instances, zoneTTL, er := findTXT(ctx, resolver, "txt."+dns.Fqdn(az))
 		if er != nil {
+			// Carry on with the other zones, but report the failure.
+			err = er
 			continue
 		}
+		// Look again once the first of the records expires.
+		if ttl == 0 || zoneTTL < ttl {
+			ttl = zoneTTL
+		}
 		for _, instance := range instances {
 			// format the service URL
-			servers = append(servers, fmt.Sprintf("http://%s:%d/%s", instance, port, urlBase))
+			servers = append(servers, fmt.Sprintf("http://%s:%d/%s", instance, e.ServicePort, e.ServerURLBase))
 		}
 	}
+	if ttl == 0 {
+		ttl = defaultDiscoveryTTL
+	}
+	if len(servers) == 0 && err == nil {
+		err = fmt.Errorf("no Eureka servers discovered under %s", domain)
+	}
 	return
 }
 
-// retryingFindTXT will, on any DNS failure, retry for up to 15 minutes before
-// giving up and returning an empty []string of records
-func retryingFindTXT(fqdn string) (records []string, ttl time.Duration, err error) {
-	err = backoff.Retry(
-		func() error {
-			records, ttl, err = findTXT(fqdn)
-			if err != nil {
-				log.Errorf("Retrying DNS query. Query failed with: %s", err.Error())
-			}
-			return err
-		}, backoff.NewExponentialBackOff())
-	return
+// discoverServiceURLs discovers the connection's Eureka servers via DNS, returning their service
+// URLs grouped into tiers in order of preference, along with the relative weights with which to
+// choose among the servers in each tier, if any. Like discoverDNS, it may return some servers
+// along with an error.
+func (e *EurekaConnection) discoverServiceURLs(ctx context.Context) (tiers [][]string, weights map[string]int, ttl time.Duration, err error) {
+	if e.DiscoverySRV {
+		return e.discoverSRV(ctx)
+	}
+	servers, ttl, err := e.discoverDNS(ctx)
+	if len(servers) == 0 {
+		return nil, nil, ttl, err
+	}
+	return [][]string{servers}, nil, ttl, err
 }
 
-func findTXT(fqdn string) ([]string, time.Duration, error) {
-	defaultTTL := 120 * time.Second
-	query := new(dns.Msg)
-	query.SetQuestion(fqdn, dns.TypeTXT)
-	dnsServerAddr, err := findDnsServerAddr()
+// discoverSRV discovers the connection's Eureka servers via the SRV records published under its
+// DiscoveryZone, grouping them into tiers by priority, lowest first.
+func (e *EurekaConnection) discoverSRV(ctx context.Context) (tiers [][]string, weights map[string]int, ttl time.Duration, err error) {
+	name := dns.Fqdn(e.DiscoveryZone)
+	if _, ok := dns.IsDomainName(name); !ok {
+		err = fmt.Errorf("invalid domain name: '%s' is not a domain name", name)
+		return
+	}
+	records, ttl, err := findSRV(ctx, e.dnsResolver(), name)
 	if err != nil {
-		log.Errorf("Failure finding DNS server, err=%s", err.Error())
-		return nil, defaultTTL, err
+		return
+	}
+	sort.SliceStable(records, func(i, j int) bool {
+		return records[i].Priority < records[j].Priority
+	})
+	weights = make(map[string]int, len(records))
+	for i, srv := range records {
+		url := fmt.Sprintf("http://%s:%d/%s", strings.TrimSuffix(srv.Target, "."), srv.Port, e.ServerURLBase)
+		if i == 0 || srv.Priority != records[i-1].Priority {
+			tiers = append(tiers, nil)
+		}
+		tiers[len(tiers)-1] = append(tiers[len(tiers)-1], url)
+		weights[url] = int(srv.Weight)
 	}
+	return
+}
 
-	response, err := dns.Exchange(query, dnsServerAddr)
+func findTXT(ctx context.Context, resolver DNSResolver, fqdn string) ([]string, time.Duration, error) {
+	query := new(dns.Msg)
+	query.SetQuestion(fqdn, dns.TypeTXT)
+	response, err := resolver.Exchange(ctx, query)
 	if err != nil {
-		log.Errorf("Failure resolving name %s err=%s", fqdn, err.Error())
-		return nil, defaultTTL, err
+		log().Error("Failure resolving name", "name", fqdn, "error", err)
+		return nil, defaultDiscoveryTTL, err
 	}
 	if len(response.Answer) < 1 {
 		err := fmt.Errorf("no Eureka discovery TXT record returned for name=%s", fqdn)
-		log.Errorf("no answer for name=%s err=%s", fqdn, err.Error())
-		return nil, defaultTTL, err
+		log().Error("No answer for name", "name", fqdn, "error", err)
+		return nil, defaultDiscoveryTTL, err
 	}
 	if response.Answer[0].Header().Rrtype != dns.TypeTXT {
 		err := fmt.Errorf("did not receive TXT record back from query specifying TXT record. This should never happen.")
-		log.Errorf("Failure resolving name %s err=%s", fqdn, err.Error())
-		return nil, defaultTTL, err
+		log().Error("Failure resolving name", "name", fqdn, "error", err)
+		return nil, defaultDiscoveryTTL, err
 	}
 	txt := response.Answer[0].(*dns.TXT)
 	ttl := response.Answer[0].Header().Ttl
@@ -89,21 +195,57 @@ func findTXT(fqdn string) ([]string, time.Duration, error) {
 	return txt.Txt, time.Duration(ttl) * time.Second, nil
 }
 
-func findDnsServerAddr() (string, error) {
-	// Find a DNS server using the OS resolv.conf
-	config, err := dns.ClientConfigFromFile("/etc/resolv.conf")
+// findSRV looks up the SRV records for the given name, returning them along with the least of
+// their TTLs.
+func findSRV(ctx context.Context, resolver DNSResolver, fqdn string) ([]*dns.SRV, time.Duration, error) {
+	query := new(dns.Msg)
+	query.SetQuestion(fqdn, dns.TypeSRV)
+	response, err := resolver.Exchange(ctx, query)
 	if err != nil {
-		log.Errorf("Failure finding DNS server address from /etc/resolv.conf, err = %s", err)
-		return "", err
-	} else {
-		return config.Servers[0] + ":" + config.Port, nil
+		log().Error("Failure resolving name", "name", fqdn, "error", err)
+		return nil, defaultDiscoveryTTL, err
+	}
+	var records []*dns.SRV
+	var ttl uint32
+	for _, rr := range response.Answer {
+		srv, ok := rr.(*dns.SRV)
+		if !ok {
+			continue
+		}
+		if len(records) == 0 || srv.Hdr.Ttl < ttl {
+			ttl = srv.Hdr.Ttl
+		}
+		records = append(records, srv)
+	}
+	if len(records) == 0 {
+		err := fmt.Errorf("no Eureka discovery SRV record returned for name=%s", fqdn)
+		log().Error("No answer for name", "name", fqdn, "error", err)
+		return nil, defaultDiscoveryTTL, err
+	}
+	if ttl < 1 {
+		ttl = 1
+	}
+	return records, time.Duration(ttl) * time.Second, nil
+}
+
+// findDnsServerAddrs lists the name servers in the given resolv.conf file.
+func findDnsServerAddrs(path string) (DNSServers, error) {
+	config, err := dns.ClientConfigFromFile(path)
+	if err != nil {
+		log().Error("Failure finding DNS server address", "file", path, "error", err)
+		return nil, err
+	}
+	servers := make(DNSServers, 0, len(config.Servers))
+	for _, s := range config.Servers {
+		servers = append(servers, net.JoinHostPort(s, config.Port))
 	}
+	return servers, nil
 }
 
 func region() (string, error) {
 	zone, err := availabilityZone()
 	if err != nil {
-		log.Errorf("Could not retrieve availability zone err=%s", err.Error())
+		log().Warn("Could not retrieve availability zone, assuming region us-east-1", "error", err)
 		return "us-east-1", err
 	}
 	return zone[:len(zone)-1], nil
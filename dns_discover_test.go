@@ -3,14 +3,24 @@ package fargo
 // MIT Licensed (see README.md) - Copyright (c) 2013 Hudl <@Hudl>
 
 import (
-	. "github.com/smartystreets/goconvey/convey"
+	"context"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
 	"testing"
 	"time"
+
+	"github.com/miekg/dns"
+	. "github.com/smartystreets/goconvey/convey"
 )
 
+var systemResolver = resolvConf("/etc/resolv.conf")
+
 func TestGetNXDomain(t *testing.T) {
 	Convey("Given nonexistent domain nxd.local.", t, func() {
-		resp, _, err := findTXT("nxd.local.")
+		resp, _, err := findTXT(context.Background(), systemResolver, "nxd.local.")
 		So(err, ShouldNotBeNil)
 		So(len(resp), ShouldEqual, 0)
 	})
@@ -20,7 +30,7 @@ func TestGetNetflixTestDomain(t *testing.T) {
 	Convey("Given domain txt.us-east-1.discoverytest.netflix.net.", t, func() {
 		// TODO: use a mock DNS server to eliminate dependency on netflix
 		// keeping their discoverytest domain up
-		resp, ttl, err := findTXT("txt.us-east-1.discoverytest.netflix.net.")
+		resp, ttl, err := findTXT(context.Background(), systemResolver, "txt.us-east-1.discoverytest.netflix.net.")
 		So(err, ShouldBeNil)
 		So(ttl, ShouldEqual, 60*time.Second)
 		So(len(resp), ShouldEqual, 3)
@@ -36,7 +46,7 @@ func TestGetNetflixTestDomain(t *testing.T) {
 			}
 			Convey("And the zone records contain instances", func() {
 				for _, record := range resp {
-					servers, _, err := findTXT("txt." + record + ".")
+					servers, _, err := findTXT(context.Background(), systemResolver, "txt."+record+".")
 					So(err, ShouldBeNil)
 					So(len(servers) >= 1, ShouldEqual, true)
 					// servers should be EC2 DNS names
@@ -46,7 +56,8 @@ func TestGetNetflixTestDomain(t *testing.T) {
 		})
 	})
 	Convey("Autodiscover discoverytest.netflix.net.", t, func() {
-		servers, ttl, err := discoverDNS("discoverytest.netflix.net", 7001, "")
+		e := EurekaConnection{DiscoveryRegion: "us-east-1", DiscoveryZone: "discoverytest.netflix.net", ServicePort: 7001}
+		servers, ttl, err := e.discoverDNS(context.Background())
 		So(ttl, ShouldEqual, 60*time.Second)
 		So(err, ShouldBeNil)
 		So(len(servers), ShouldEqual, 6)
@@ -57,3 +68,320 @@ func TestGetNetflixTestDomain(t *testing.T) {
 		})
 	})
 }
+
+func txtRecord(name string, values ...string) dns.RR {
+	return &dns.TXT{
+		Hdr: dns.RR_Header{Name: name, Rrtype: dns.TypeTXT, Class: dns.ClassINET, Ttl: 300},
+		Txt: values,
+	}
+}
+
+func srvRecord(name string, ttl uint32, priority, weight, port uint16, target string) dns.RR {
+	return &dns.SRV{
+		Hdr:      dns.RR_Header{Name: name, Rrtype: dns.TypeSRV, Class: dns.ClassINET, Ttl: ttl},
+		Priority: priority,
+		Weight:   weight,
+		Port:     port,
+		Target:   target,
+	}
+}
+
+// fakeNameServer serves DNS records from a local name server.
+type fakeNameServer struct {
+	addr    string
+	m       sync.Mutex
+	records []dns.RR
+	failure int
+	asked   chan string
+}
+
+// set replaces the records the name server serves, answering queries for other names with
+// NXDOMAIN, or every query with the given failure code if it's nonzero.
+func (s *fakeNameServer) set(records []dns.RR, failure int) {
+	s.m.Lock()
+	defer s.m.Unlock()
+	s.records, s.failure = records, failure
+}
+
+// queries returns the names queried since it was last called.
+func (s *fakeNameServer) queries() []string {
+	var names []string
+	for {
+		select {
+		case name := <-s.asked:
+			names = append(names, name)
+		default:
+			return names
+		}
+	}
+}
+
+func (s *fakeNameServer) ServeDNS(w dns.ResponseWriter, r *dns.Msg) {
+	s.m.Lock()
+	defer s.m.Unlock()
+	m := new(dns.Msg)
+	m.SetReply(r)
+	q := r.Question[0]
+	select {
+	case s.asked <- q.Name:
+	default:
+	}
+	for _, rr := range s.records {
+		if h := rr.Header(); h.Name == q.Name && h.Rrtype == q.Qtype {
+			m.Answer = append(m.Answer, rr)
+		}
+	}
+	if s.failure != 0 {
+		m.Answer = nil
+		m.Rcode = s.failure
+	} else if len(m.Answer) == 0 {
+		m.Rcode = dns.RcodeNameError
+	}
+	w.WriteMsg(m)
+}
+
+func startDNSServer(t *testing.T, records []dns.RR, failure int) *fakeNameServer {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ns := &fakeNameServer{addr: pc.LocalAddr().String(), asked: make(chan string, 100)}
+	ns.set(records, failure)
+	s := &dns.Server{PacketConn: pc, Handler: ns}
+	started := make(chan struct{})
+	s.NotifyStartedFunc = func() { close(started) }
+	go s.ActivateAndServe()
+	<-started
+	t.Cleanup(func() { s.Shutdown() })
+	return ns
+}
+
+func TestDiscoverDNSLocally(t *testing.T) {
+	Convey("Given a name server publishing Eureka servers in two zones", t, func() {
+		shortLived := txtRecord("txt.eu-west-1b.eureka.example.com.", "eureka3.example.com")
+		shortLived.Header().Ttl = 90
+		ns := startDNSServer(t, []dns.RR{
+			txtRecord("txt.eu-west-1.eureka.example.com.",
+				"eu-west-1a.eureka.example.com",
+				"eu-west-1b.eureka.example.com"),
+			txtRecord("txt.eu-west-1a.eureka.example.com.", "eureka1.example.com", "eureka2.example.com"),
+			shortLived,
+		}, 0)
+		e := EurekaConnection{
+			DNSDiscovery:    true,
+			DiscoveryZone:   "eureka.example.com",
+			DiscoveryRegion: "eu-west-1",
+			ServicePort:     8080,
+			ServerURLBase:   "eureka/v2",
+			DNSResolver:     DNSServers{ns.addr},
+		}
+
+		Convey("a connection discovers the servers in each of the region's zones", func() {
+			servers, ttl, err := e.discoverDNS(context.Background())
+			So(err, ShouldBeNil)
+			So(ttl, ShouldEqual, 90*time.Second)
+			So(servers, ShouldResemble, []string{
+				"http://eureka1.example.com:8080/eureka/v2",
+				"http://eureka2.example.com:8080/eureka/v2",
+				"http://eureka3.example.com:8080/eureka/v2",
+			})
+			So(e.SelectServiceURL(), ShouldBeIn, servers)
+		})
+
+		Convey("a connection limited to some zones discovers only their servers", func() {
+			e.DiscoveryZones = []string{"eu-west-1b"}
+			ns.queries()
+			servers, ttl, err := e.discoverDNS(context.Background())
+			So(err, ShouldBeNil)
+			So(servers, ShouldResemble, []string{"http://eureka3.example.com:8080/eureka/v2"})
+			So(ttl, ShouldEqual, 90*time.Second)
+			So(ns.queries(), ShouldResemble, []string{"txt.eu-west-1b.eureka.example.com."})
+
+			e.DiscoveryZones = []string{"eu-west-1a"}
+			_, ttl, err = e.discoverDNS(context.Background())
+			So(err, ShouldBeNil)
+			So(ttl, ShouldEqual, 300*time.Second)
+		})
+
+		Convey("a connection in a region without servers fails to discover any", func() {
+			e.DiscoveryZones = []string{"eu-west-1c"}
+			ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+			defer cancel()
+			_, _, err := e.discoverDNS(ctx)
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("and another name server that is failing", func() {
+			failing := startDNSServer(t, nil, dns.RcodeServerFailure)
+			e.DNSResolver = DNSServers{failing.addr, ns.addr}
+
+			Convey("a connection moves on to the next name server", func() {
+				servers, _, err := e.discoverDNS(context.Background())
+				So(err, ShouldBeNil)
+				So(servers, ShouldHaveLength, 3)
+				So(failing.queries(), ShouldNotBeEmpty)
+			})
+		})
+	})
+
+	Convey("Given a resolv.conf file listing several name servers", t, func() {
+		dir, err := ioutil.TempDir("", "fargo")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+		path := filepath.Join(dir, "resolv.conf")
+		So(ioutil.WriteFile(path, []byte("nameserver 10.0.0.2\nnameserver 2001:db8::53\n"), 0600), ShouldBeNil)
+
+		Convey("all of them are used", func() {
+			servers, err := findDnsServerAddrs(path)
+			So(err, ShouldBeNil)
+			So(servers, ShouldResemble, DNSServers{"10.0.0.2:53", "[2001:db8::53]:53"})
+		})
+	})
+}
+
+func TestDiscoverSRV(t *testing.T) {
+	Convey("Given a name server publishing Eureka servers via SRV records", t, func() {
+		const name = "_eureka._tcp.example.com."
+		ns := startDNSServer(t, []dns.RR{
+			srvRecord(name, 30, 10, 3, 8761, "eureka1.example.com."),
+			srvRecord(name, 15, 10, 1, 8762, "eureka2.example.com."),
+			srvRecord(name, 30, 20, 0, 8761, "eureka3.example.com."),
+			txtRecord(name, "ignored"),
+		}, 0)
+		e := EurekaConnection{
+			DNSDiscovery:  true,
+			DiscoverySRV:  true,
+			DiscoveryZone: "_eureka._tcp.example.com",
+			ServerURLBase: "eureka/v2",
+			DNSResolver:   DNSServers{ns.addr},
+		}
+
+		Convey("a connection discovers the servers, grouped by priority", func() {
+			tiers, weights, ttl, err := e.discoverServiceURLs(context.Background())
+			So(err, ShouldBeNil)
+			So(ttl, ShouldEqual, 15*time.Second)
+			So(tiers, ShouldResemble, [][]string{
+				{"http://eureka1.example.com:8761/eureka/v2", "http://eureka2.example.com:8762/eureka/v2"},
+				{"http://eureka3.example.com:8761/eureka/v2"},
+			})
+			So(weights["http://eureka1.example.com:8761/eureka/v2"], ShouldEqual, 3)
+			So(weights["http://eureka2.example.com:8762/eureka/v2"], ShouldEqual, 1)
+
+			Convey("preferring those with the lowest priority, per their weights", func() {
+				counts := map[string]int{}
+				for i := 0; i != 400; i++ {
+					counts[e.SelectServiceURL()]++
+				}
+				So(counts, ShouldHaveLength, 2)
+				So(counts["http://eureka1.example.com:8761/eureka/v2"], ShouldBeGreaterThan, counts["http://eureka2.example.com:8762/eureka/v2"])
+			})
+
+			Convey("falling back to those with higher priority while they're failing", func() {
+				e.SelectServiceURL()
+				quarantine.failed("http://eureka1.example.com:8761/eureka/v2")
+				quarantine.failed("http://eureka2.example.com:8762/eureka/v2")
+				defer quarantine.succeeded("http://eureka1.example.com:8761/eureka/v2")
+				defer quarantine.succeeded("http://eureka2.example.com:8762/eureka/v2")
+				So(e.SelectServiceURL(), ShouldEqual, "http://eureka3.example.com:8761/eureka/v2")
+			})
+		})
+
+		Convey("a connection fails to discover servers under a name without SRV records", func() {
+			e.DiscoveryZone = "_eureka._udp.example.com"
+			ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+			defer cancel()
+			_, _, _, err := e.discoverServiceURLs(ctx)
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
+
+func TestDNSDiscoveryRefresh(t *testing.T) {
+	Convey("Given a connection discovering servers from a name server with short TTLs", t, func() {
+		const name = "_eureka._tcp.refresh.example.com."
+		ns := startDNSServer(t, []dns.RR{srvRecord(name, 1, 10, 1, 8761, "eureka1.example.com.")}, 0)
+		e := EurekaConnection{
+			DNSDiscovery:  true,
+			DiscoverySRV:  true,
+			DiscoveryZone: name,
+			ServerURLBase: "eureka/v2",
+			DNSResolver:   DNSServers{ns.addr},
+		}
+		awaitState := func(done func(DNSDiscoveryState) bool) DNSDiscoveryState {
+			for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+				if s := e.DNSDiscoveryState(); done(s) {
+					return s
+				}
+			}
+			return e.DNSDiscoveryState()
+		}
+		So(e.SelectServiceURL(), ShouldEqual, "http://eureka1.example.com:8761/eureka/v2")
+		state := e.DNSDiscoveryState()
+		So(state.ServiceUrls, ShouldResemble, []string{"http://eureka1.example.com:8761/eureka/v2"})
+		So(state.Err, ShouldBeNil)
+		So(state.Refreshed.IsZero(), ShouldBeFalse)
+
+		Convey("the connection picks up changes to the records in the background", func() {
+			ns.set([]dns.RR{srvRecord(name, 1, 10, 1, 8761, "eureka2.example.com.")}, 0)
+			state := awaitState(func(s DNSDiscoveryState) bool {
+				return len(s.ServiceUrls) == 1 && s.ServiceUrls[0] == "http://eureka2.example.com:8761/eureka/v2"
+			})
+			So(state.ServiceUrls, ShouldResemble, []string{"http://eureka2.example.com:8761/eureka/v2"})
+			So(e.SelectServiceURL(), ShouldEqual, "http://eureka2.example.com:8761/eureka/v2")
+
+			Convey("and keeps the servers it last discovered while the name server fails", func() {
+				ns.set(nil, dns.RcodeServerFailure)
+				state := awaitState(func(s DNSDiscoveryState) bool { return s.Err != nil })
+				So(state.Err, ShouldNotBeNil)
+				So(state.ServiceUrls, ShouldResemble, []string{"http://eureka2.example.com:8761/eureka/v2"})
+				So(e.SelectServiceURL(), ShouldEqual, "http://eureka2.example.com:8761/eureka/v2")
+			})
+		})
+	})
+
+	Convey("Discovery stops once no connection has asked for it for a while", t, func() {
+		defer func(d time.Duration) { dnsDiscoveryIdleTimeout = d }(dnsDiscoveryIdleTimeout)
+		dnsDiscoveryIdleTimeout = 50 * time.Millisecond
+		const name = "_eureka._tcp.idle.example.com."
+		ns := startDNSServer(t, []dns.RR{srvRecord(name, 1, 10, 1, 8761, "eureka1.example.com.")}, 0)
+		e := EurekaConnection{
+			DNSDiscovery:  true,
+			DiscoverySRV:  true,
+			DiscoveryZone: name,
+			ServerURLBase: "eureka/v2",
+			DNSResolver:   DNSServers{ns.addr},
+		}
+		d := e.dnsDiscovery()
+		running := func() bool {
+			dnsDiscoveries.Lock()
+			defer dnsDiscoveries.Unlock()
+			return dnsDiscoveries.m[d.key] == d
+		}
+		So(running(), ShouldBeTrue)
+		for deadline := time.Now().Add(5 * time.Second); running() && time.Now().Before(deadline); {
+			time.Sleep(10 * time.Millisecond)
+		}
+		So(running(), ShouldBeFalse)
+		ns.queries()
+		time.Sleep(1500 * time.Millisecond)
+		So(ns.queries(), ShouldBeEmpty)
+
+		Convey("and starts afresh when asked again", func() {
+			So(e.SelectServiceURL(), ShouldEqual, "http://eureka1.example.com:8761/eureka/v2")
+			So(e.dnsDiscovery(), ShouldNotPointTo, d)
+		})
+	})
+
+	Convey("A connection that can't discover any servers uses its configured service URLs", t, func() {
+		ns := startDNSServer(t, nil, dns.RcodeServerFailure)
+		e := EurekaConnection{
+			ServiceUrls:   []string{"http://eureka.example.com:8080/eureka/v2"},
+			DNSDiscovery:  true,
+			DiscoverySRV:  true,
+			DiscoveryZone: "_eureka._tcp.fallback.example.com",
+			DNSResolver:   DNSServers{ns.addr},
+		}
+		So(e.SelectServiceURL(), ShouldEqual, "http://eureka.example.com:8080/eureka/v2")
+		So(e.DNSDiscoveryState().Err, ShouldNotBeNil)
+	})
+}
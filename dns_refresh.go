@@ -0,0 +1,170 @@
+package fargo
+
+// MIT Licensed (see README.md) - Copyright (c) 2013 Hudl <@Hudl>
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cenkalti/backoff"
+)
+
+// discoveryTimeout bounds the time allowed for each attempt to discover the Eureka servers.
+const discoveryTimeout = 30 * time.Second
+
+// dnsDiscoveryIdleTimeout is how long a dnsDiscovery keeps refreshing its list of servers after
+// the last connection asked for it.
+var dnsDiscoveryIdleTimeout = 10 * time.Minute
+
+// DNSDiscoveryState describes the Eureka servers that a connection has discovered via DNS.
+type DNSDiscoveryState struct {
+	// ServiceUrls lists the service URLs of the servers discovered most recently, in order of
+	// preference.
+	ServiceUrls []string
+	// Refreshed is when the servers were last discovered successfully, or zero if they never were.
+	Refreshed time.Time
+	// Err is the error from the latest attempt to discover the servers, or nil if it succeeded.
+	Err error
+}
+
+// dnsDiscovery keeps a list of Eureka servers discovered via DNS, refreshing it in the background
+// as the records' TTLs expire. Should a refresh fail, it keeps the last list it discovered, trying
+// again with exponential backoff. It stops once no connection has asked for it for
+// dnsDiscoveryIdleTimeout.
+type dnsDiscovery struct {
+	key       dnsDiscoveryKey
+	used      time.Time // guarded by dnsDiscoveries
+	ready     chan struct{}
+	m         sync.RWMutex
+	tiers     [][]string
+	weights   map[string]int
+	refreshed time.Time
+	err       error
+}
+
+// dnsDiscoveryKey identifies the connection settings that determine which servers DNS discovery
+// finds, such that connections with like settings share a dnsDiscovery.
+type dnsDiscoveryKey struct {
+	srv           bool
+	zone          string
+	region        string
+	zones         string
+	servicePort   int
+	serverURLBase string
+	resolver      string
+}
+
+var dnsDiscoveries = struct {
+	sync.Mutex
+	m map[dnsDiscoveryKey]*dnsDiscovery
+}{m: make(map[dnsDiscoveryKey]*dnsDiscovery)}
+
+// dnsDiscovery returns the dnsDiscovery for the connection's settings, starting one if none is
+// running yet. Connections are copied freely, so the dnsDiscovery lives not with any one copy, but
+// for as long as connections keep asking for it.
+func (e *EurekaConnection) dnsDiscovery() *dnsDiscovery {
+	key := dnsDiscoveryKey{
+		srv:           e.DiscoverySRV,
+		zone:          e.DiscoveryZone,
+		region:        e.DiscoveryRegion,
+		zones:         strings.Join(e.DiscoveryZones, ","),
+		servicePort:   e.ServicePort,
+		serverURLBase: e.ServerURLBase,
+	}
+	if e.DNSResolver != nil {
+		key.resolver = fmt.Sprintf("%T %v", e.DNSResolver, e.DNSResolver)
+	}
+	dnsDiscoveries.Lock()
+	defer dnsDiscoveries.Unlock()
+	d, ok := dnsDiscoveries.m[key]
+	if !ok {
+		d = &dnsDiscovery{key: key, ready: make(chan struct{})}
+		dnsDiscoveries.m[key] = d
+		go d.run(*e)
+	}
+	d.used = time.Now()
+	return d
+}
+
+// retireIfIdle removes the dnsDiscovery from the registry, reporting true, if no connection has
+// asked for it lately.
+func (d *dnsDiscovery) retireIfIdle() bool {
+	dnsDiscoveries.Lock()
+	defer dnsDiscoveries.Unlock()
+	if time.Since(d.used) < dnsDiscoveryIdleTimeout {
+		return false
+	}
+	if dnsDiscoveries.m[d.key] == d {
+		delete(dnsDiscoveries.m, d.key)
+	}
+	return true
+}
+
+func (d *dnsDiscovery) run(e EurekaConnection) {
+	b := backoff.NewExponentialBackOff()
+	b.MaxInterval = defaultDiscoveryTTL
+	b.MaxElapsedTime = 0
+	for first := true; ; first = false {
+		ctx, cancel := context.WithTimeout(context.Background(), discoveryTimeout)
+		tiers, weights, ttl, err := e.discoverServiceURLs(ctx)
+		cancel()
+		next := ttl
+		d.m.Lock()
+		d.err = err
+		if err == nil {
+			d.tiers, d.weights, d.refreshed = tiers, weights, time.Now()
+			b.Reset()
+		} else {
+			if len(d.tiers) == 0 && len(tiers) > 0 {
+				// Some servers are better than none.
+				d.tiers, d.weights = tiers, weights
+			}
+			next = b.NextBackOff()
+			log().Error("Failure discovering Eureka servers via DNS", "name", e.DiscoveryZone, "error", err, "duration", next)
+		}
+		d.m.Unlock()
+		if first {
+			close(d.ready)
+		}
+		time.Sleep(next)
+		if d.retireIfIdle() {
+			log().Debug("Stopped idle discovery of Eureka servers via DNS", "name", e.DiscoveryZone)
+			return
+		}
+	}
+}
+
+// current returns the servers most recently discovered, waiting for the first attempt to discover
+// them to finish unless the given context is done sooner.
+func (d *dnsDiscovery) current(ctx context.Context) (tiers [][]string, weights map[string]int) {
+	select {
+	case <-d.ready:
+	case <-ctx.Done():
+	}
+	d.m.RLock()
+	defer d.m.RUnlock()
+	return d.tiers, d.weights
+}
+
+func (d *dnsDiscovery) state() DNSDiscoveryState {
+	d.m.RLock()
+	defer d.m.RUnlock()
+	s := DNSDiscoveryState{Refreshed: d.refreshed, Err: d.err}
+	for _, tier := range d.tiers {
+		s.ServiceUrls = append(s.ServiceUrls, tier...)
+	}
+	return s
+}
+
+// DNSDiscoveryState reports the Eureka servers that the connection has discovered via DNS, and
+// whether its latest attempt to refresh them failed, starting discovery if it hasn't begun. It
+// returns the zero value if the connection doesn't use DNS discovery.
+func (e *EurekaConnection) DNSDiscoveryState() DNSDiscoveryState {
+	if !e.DNSDiscovery {
+		return DNSDiscoveryState{}
+	}
+	return e.dnsDiscovery().state()
+}
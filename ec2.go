@@ -0,0 +1,178 @@
+package fargo
+
+// MIT Licensed (see README.md) - Copyright (c) 2013 Hudl <@Hudl>
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultEC2MetadataEndpoint is the base URL of the EC2 instance metadata service.
+const DefaultEC2MetadataEndpoint = "http://169.254.169.254"
+
+const (
+	defaultEC2MetadataTimeout  = 2 * time.Second
+	defaultEC2MetadataTokenTTL = 6 * time.Hour
+	ec2MetadataTokenHeader     = "X-aws-ec2-metadata-token"
+	ec2MetadataTokenTTLHeader  = "X-aws-ec2-metadata-token-ttl-seconds"
+)
+
+// An EC2MetadataClient retrieves details about the EC2 instance on which it runs from the
+// instance metadata service, using a session token per IMDSv2 where the service supports it, and
+// falling back to IMDSv1 otherwise. Its zero value is ready to use.
+type EC2MetadataClient struct {
+	// Endpoint is the base URL of the instance metadata service. If empty,
+	// DefaultEC2MetadataEndpoint is used.
+	Endpoint string
+	// HTTPClient sends the requests to the metadata service. If nil, http.DefaultClient is used.
+	HTTPClient *http.Client
+	// Timeout bounds the time allowed for each request to the metadata service. If zero, each
+	// request may take up to two seconds.
+	Timeout time.Duration
+	// TokenTTL is the lifetime requested for IMDSv2 session tokens. If zero, tokens last six hours.
+	TokenTTL time.Duration
+}
+
+func (c *EC2MetadataClient) endpoint() string {
+	if len(c.Endpoint) > 0 {
+		return strings.TrimSuffix(c.Endpoint, "/")
+	}
+	return DefaultEC2MetadataEndpoint
+}
+
+func (c *EC2MetadataClient) timeout() time.Duration {
+	if c.Timeout > 0 {
+		return c.Timeout
+	}
+	return defaultEC2MetadataTimeout
+}
+
+func (c *EC2MetadataClient) do(ctx context.Context, method, path string, header http.Header) (int, string, error) {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout())
+	defer cancel()
+	req, err := http.NewRequest(method, c.endpoint()+path, nil)
+	if err != nil {
+		return -1, "", err
+	}
+	req = req.WithContext(ctx)
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	client := c.HTTPClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return -1, "", err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return -1, "", err
+	}
+	return resp.StatusCode, string(body), nil
+}
+
+// token requests an IMDSv2 session token, returning an empty token if the service doesn't offer
+// them.
+func (c *EC2MetadataClient) token(ctx context.Context) (string, error) {
+	ttl := c.TokenTTL
+	if ttl <= 0 {
+		ttl = defaultEC2MetadataTokenTTL
+	}
+	header := http.Header{}
+	header.Set(ec2MetadataTokenTTLHeader, strconv.Itoa(int(ttl/time.Second)))
+	code, body, err := c.do(ctx, "PUT", "/latest/api/token", header)
+	if err != nil {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return "", ctxErr
+		}
+		log().Debug("Could not acquire EC2 metadata token, falling back to IMDSv1", "error", err)
+		return "", nil
+	}
+	if code < 200 || code >= 300 {
+		log().Debug("Could not acquire EC2 metadata token, falling back to IMDSv1", "status", code)
+		return "", nil
+	}
+	return body, nil
+}
+
+// get retrieves the metadata item with the given key, such as "instance-id", reporting whether
+// the item is present.
+func (c *EC2MetadataClient) get(ctx context.Context, token, key string) (string, bool, error) {
+	var header http.Header
+	if len(token) > 0 {
+		header = http.Header{}
+		header.Set(ec2MetadataTokenHeader, token)
+	}
+	code, body, err := c.do(ctx, "GET", "/latest/meta-data/"+key, header)
+	if err != nil {
+		return "", false, err
+	}
+	switch {
+	case code == http.StatusNotFound:
+		return "", false, nil
+	case code < 200 || code >= 300:
+		return "", false, &unsuccessfulHTTPResponse{code, "failed to retrieve EC2 metadata item " + key}
+	}
+	return strings.TrimSpace(body), true, nil
+}
+
+// AmazonMetadata retrieves the details of the EC2 instance that Eureka expects for instances
+// hosted in Amazon data centers. Items absent from the metadata service, such as the public IPv4
+// address of an instance that lacks one, are left empty, but it returns an error if the instance
+// ID is absent or any item can't be retrieved.
+func (c *EC2MetadataClient) AmazonMetadata(ctx context.Context) (AmazonMetadataType, error) {
+	var md AmazonMetadataType
+	token, err := c.token(ctx)
+	if err != nil {
+		return md, err
+	}
+	items := []struct {
+		key   string
+		field *string
+	}{
+		{"instance-id", &md.InstanceID},
+		{"ami-id", &md.AmiID},
+		{"ami-launch-index", &md.AmiLaunchIndex},
+		{"ami-manifest-path", &md.AmiManifestPath},
+		{"instance-type", &md.InstanceType},
+		{"placement/availability-zone", &md.AvailabilityZone},
+		{"hostname", &md.HostName},
+		{"local-hostname", &md.LocalHostname},
+		{"local-ipv4", &md.LocalIpv4},
+		{"public-hostname", &md.PublicHostname},
+		{"public-ipv4", &md.PublicIpv4},
+	}
+	for _, item := range items {
+		v, present, err := c.get(ctx, token, item.key)
+		if err != nil {
+			return md, fmt.Errorf("retrieving EC2 metadata item %s: %v", item.key, err)
+		}
+		if !present && item.key == "instance-id" {
+			return md, ErrNotInAWS
+		}
+		*item.field = v
+	}
+	return md, nil
+}
+
+// PopulateDataCenterInfo marks the given instance as hosted in an Amazon data center, filling in
+// its DataCenterInfo.Metadata field with the details of the EC2 instance on which it runs, such
+// that the instance's Id method yields the EC2 instance ID. It leaves the instance unchanged if it
+// can't retrieve those details.
+func (c *EC2MetadataClient) PopulateDataCenterInfo(ctx context.Context, ins *Instance) error {
+	md, err := c.AmazonMetadata(ctx)
+	if err != nil {
+		return err
+	}
+	ins.DataCenterInfo.Name = Amazon
+	ins.DataCenterInfo.Metadata = md
+	return nil
+}
@@ -0,0 +1,131 @@
+package fargo
+
+// MIT Licensed (see README.md) - Copyright (c) 2013 Hudl <@Hudl>
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// fakeEC2Metadata stands in for the EC2 instance metadata service.
+func fakeEC2Metadata(items map[string]string, requireToken, offerToken bool) *httptest.Server {
+	const token = "t0ken"
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/latest/api/token" {
+			if !offerToken || r.Method != "PUT" {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			if r.Header.Get(ec2MetadataTokenTTLHeader) == "" {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			w.Write([]byte(token))
+			return
+		}
+		if requireToken && r.Header.Get(ec2MetadataTokenHeader) != token {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		v, ok := items[strings.TrimPrefix(r.URL.Path, "/latest/meta-data/")]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Write([]byte(v))
+	}))
+}
+
+func TestEC2MetadataClient(t *testing.T) {
+	items := map[string]string{
+		"instance-id":                 "i-0123456789abcdef0",
+		"ami-id":                      "ami-12345678",
+		"ami-launch-index":            "0",
+		"ami-manifest-path":           "(unknown)",
+		"instance-type":               "m5.large",
+		"placement/availability-zone": "us-east-1a",
+		"hostname":                    "ip-10-0-0-1.ec2.internal",
+		"local-hostname":              "ip-10-0-0-1.ec2.internal",
+		"local-ipv4":                  "10.0.0.1",
+	}
+	expected := AmazonMetadataType{
+		InstanceID:       "i-0123456789abcdef0",
+		AmiID:            "ami-12345678",
+		AmiLaunchIndex:   "0",
+		AmiManifestPath:  "(unknown)",
+		InstanceType:     "m5.large",
+		AvailabilityZone: "us-east-1a",
+		HostName:         "ip-10-0-0-1.ec2.internal",
+		LocalHostname:    "ip-10-0-0-1.ec2.internal",
+		LocalIpv4:        "10.0.0.1",
+	}
+	ctx := context.Background()
+
+	Convey("Given a metadata service that requires IMDSv2 tokens", t, func() {
+		s := fakeEC2Metadata(items, true, true)
+		defer s.Close()
+		c := &EC2MetadataClient{Endpoint: s.URL + "/"}
+
+		Convey("it retrieves the instance's metadata, leaving absent items empty", func() {
+			md, err := c.AmazonMetadata(ctx)
+			So(err, ShouldBeNil)
+			So(md, ShouldResemble, expected)
+		})
+
+		Convey("it populates an instance's data center info", func() {
+			ins := &Instance{HostName: "host.example.com", DataCenterInfo: DataCenterInfo{Name: MyOwn}}
+			So(c.PopulateDataCenterInfo(ctx, ins), ShouldBeNil)
+			So(ins.DataCenterInfo.Name, ShouldEqual, Amazon)
+			So(ins.DataCenterInfo.Metadata, ShouldResemble, expected)
+			So(ins.Id(), ShouldEqual, "i-0123456789abcdef0")
+		})
+	})
+
+	Convey("Given a metadata service that only supports IMDSv1", t, func() {
+		s := fakeEC2Metadata(items, false, false)
+		defer s.Close()
+		c := &EC2MetadataClient{Endpoint: s.URL}
+
+		Convey("it retrieves the instance's metadata without a token", func() {
+			md, err := c.AmazonMetadata(ctx)
+			So(err, ShouldBeNil)
+			So(md, ShouldResemble, expected)
+		})
+	})
+
+	Convey("Given a metadata service that lacks an instance ID", t, func() {
+		s := fakeEC2Metadata(map[string]string{}, false, true)
+		defer s.Close()
+		c := &EC2MetadataClient{Endpoint: s.URL}
+
+		Convey("it reports that it's not running in AWS and leaves the instance unchanged", func() {
+			ins := &Instance{DataCenterInfo: DataCenterInfo{Name: MyOwn}}
+			So(c.PopulateDataCenterInfo(ctx, ins), ShouldEqual, ErrNotInAWS)
+			So(ins.DataCenterInfo.Name, ShouldEqual, MyOwn)
+		})
+	})
+
+	Convey("Given a metadata service that doesn't respond in time", t, func() {
+		s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			select {
+			case <-r.Context().Done():
+			case <-time.After(time.Second):
+			}
+		}))
+		defer s.Close()
+		c := &EC2MetadataClient{Endpoint: s.URL, Timeout: 10 * time.Millisecond}
+
+		Convey("it gives up", func() {
+			start := time.Now()
+			_, err := c.AmazonMetadata(ctx)
+			So(err, ShouldNotBeNil)
+			So(time.Since(start), ShouldBeLessThan, 500*time.Millisecond)
+		})
+	})
+}
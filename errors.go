@@ -4,6 +4,7 @@ package fargo
 
 import (
 	"fmt"
+	"net/http"
 )
 
 type unsuccessfulHTTPResponse struct {
@@ -22,8 +23,11 @@ func (u *unsuccessfulHTTPResponse) Error() string {
 // the supplied error, if any. If the returned present value is true, the returned code is an HTTP
 // status code.
 func HTTPResponseStatusCode(err error) (code int, present bool) {
-	if u, ok := err.(*unsuccessfulHTTPResponse); ok {
-		return u.statusCode, true
+	switch e := err.(type) {
+	case *unsuccessfulHTTPResponse:
+		return e.statusCode, true
+	case InstanceNotFoundError:
+		return http.StatusNotFound, true
 	}
 	return 0, false
 }
@@ -35,3 +39,30 @@ type AppNotFoundError struct {
 func (e AppNotFoundError) Error() string {
 	return "Application not found for name=" + e.specific
 }
+
+// InstanceNotFoundError indicates that Eureka has no instance registered with a given ID in a
+// given application.
+type InstanceNotFoundError struct {
+	app string
+	id  string
+}
+
+func (e InstanceNotFoundError) Error() string {
+	return "Instance not found for app=" + e.app + ", id=" + e.id
+}
+
+// MetadataError describes a failure to bind an instance metadata item to or from a Go value,
+// naming the item's key.
+type MetadataError struct {
+	Key string
+	Err error
+}
+
+func (e *MetadataError) Error() string {
+	return fmt.Sprintf("metadata key %q: %v", e.Key, e.Err)
+}
+
+// Unwrap returns the underlying error.
+func (e *MetadataError) Unwrap() error {
+	return e.Err
+}
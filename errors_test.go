@@ -49,4 +49,11 @@ func TestHTTPResponseStatusCode(t *testing.T) {
 			verify(&unsuccessfulHTTPResponse{statusCode: 500})
 		})
 	})
+	Convey("A missing instance error should have a 404 HTTP status code", t, func() {
+		err := InstanceNotFoundError{app: "TESTAPP", id: "i-1"}
+		code, present := HTTPResponseStatusCode(err)
+		So(present, ShouldBeTrue)
+		So(code, ShouldEqual, 404)
+		So(err.Error(), ShouldContainSubstring, "i-1")
+	})
 }
@@ -86,3 +86,27 @@ func ExampleInstanceSetSource_Latest_app() {
 		time.Sleep(30 * time.Second)
 	}
 }
+
+func ExampleInstanceSetSource_Subscribe() {
+	e := makeConnection()
+	source, err := e.NewInstanceSetSourceForVIPAddress("my_vip", false, true)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	defer source.Stop()
+	unsubscribe := source.Subscribe(func(changes []fargo.InstanceChange) {
+		for _, c := range changes {
+			switch c.Type {
+			case fargo.InstanceAdded:
+				fmt.Printf("Opening connections to instance %s.\n", c.Instance.Id())
+			case fargo.InstanceRemoved:
+				fmt.Printf("Draining connections to instance %s.\n", c.Instance.Id())
+			case fargo.InstanceStatusChanged:
+				fmt.Printf("Instance %s is now %s.\n", c.Instance.Id(), c.Instance.Status)
+			}
+		}
+	})
+	defer unsubscribe()
+	time.Sleep(time.Minute)
+}
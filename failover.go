@@ -0,0 +1,115 @@
+package fargo
+
+// MIT Licensed (see README.md) - Copyright (c) 2013 Hudl <@Hudl>
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	// defaultRetries is the number of times a connection retries a failed request when its
+	// Retries field is zero.
+	defaultRetries = 3
+	// minQuarantine and maxQuarantine bound the period for which a failing Eureka server is
+	// passed over when selecting a server for subsequent requests. The period doubles with each
+	// consecutive failure.
+	minQuarantine = 5 * time.Second
+	maxQuarantine = 5 * time.Minute
+)
+
+type quarantineEntry struct {
+	failures int
+	until    time.Time
+}
+
+// serverQuarantine tracks Eureka servers that recently failed to handle requests, keyed by
+// service URL. It's shared by all connections, since connections are routinely copied by value.
+type serverQuarantine struct {
+	m       sync.Mutex
+	entries map[string]*quarantineEntry
+}
+
+var quarantine = &serverQuarantine{entries: make(map[string]*quarantineEntry)}
+
+// failed notes that the server with the given service URL failed to handle a request, and
+// quarantines it for a period that grows with each consecutive failure, returning that period.
+func (q *serverQuarantine) failed(serviceURL string) time.Duration {
+	q.m.Lock()
+	defer q.m.Unlock()
+	e, ok := q.entries[serviceURL]
+	if !ok {
+		e = &quarantineEntry{}
+		q.entries[serviceURL] = e
+	}
+	d := minQuarantine << uint(e.failures)
+	if d > maxQuarantine || d <= 0 {
+		d = maxQuarantine
+	} else {
+		e.failures++
+	}
+	e.until = time.Now().Add(d)
+	return d
+}
+
+// succeeded releases the server with the given service URL from quarantine.
+func (q *serverQuarantine) succeeded(serviceURL string) {
+	q.m.Lock()
+	defer q.m.Unlock()
+	delete(q.entries, serviceURL)
+}
+
+// available returns the service URLs worth trying next, drawn from the given tiers of candidates
+// in order of preference. It prefers the first tier with candidates neither excluded nor
+// quarantined. If every candidate is either excluded or quarantined, it falls back to the first
+// tier with candidates that aren't excluded, and failing that, to all of the candidates.
+func (q *serverQuarantine) available(tiers [][]string, exclude []string) []string {
+	q.m.Lock()
+	defer q.m.Unlock()
+	now := time.Now()
+	var all, untried []string
+	for _, tier := range tiers {
+		var healthy, untriedInTier []string
+		for _, c := range tier {
+			all = append(all, c)
+			if contains(exclude, c) {
+				continue
+			}
+			untriedInTier = append(untriedInTier, c)
+			if e, ok := q.entries[c]; !ok || now.After(e.until) {
+				healthy = append(healthy, c)
+			}
+		}
+		if len(healthy) > 0 {
+			return healthy
+		}
+		if len(untried) == 0 {
+			untried = untriedInTier
+		}
+	}
+	if len(untried) > 0 {
+		return untried
+	}
+	return all
+}
+
+func contains(ss []string, s string) bool {
+	for _, e := range ss {
+		if e == s {
+			return true
+		}
+	}
+	return false
+}
+
+// attempts returns the maximum number of attempts the connection makes to complete a request.
+func (e *EurekaConnection) attempts() int {
+	switch {
+	case e.Retries > 0:
+		return e.Retries + 1
+	case e.Retries < 0:
+		return 1
+	default:
+		return defaultRetries + 1
+	}
+}
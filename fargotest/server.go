@@ -0,0 +1,517 @@
+// Package fargotest provides a fake Eureka server for use in tests of code that uses fargo.
+package fargotest
+
+// MIT Licensed (see README.md) - Copyright (c) 2013 Hudl <@Hudl>
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hudl/fargo"
+)
+
+// BasePath is the path at which a Server serves the Eureka API, matching that of a stock Eureka
+// server.
+const BasePath = "/eureka/v2"
+
+const (
+	// defaultLeaseDuration is the lease duration the Eureka server uses for instances that don't
+	// specify one.
+	defaultLeaseDuration = 90 * time.Second
+	// deltaRetention is the period for which the Eureka server retains changes to report in the
+	// registry delta.
+	deltaRetention = 3 * time.Minute
+)
+
+type lease struct {
+	instance    fargo.Instance
+	metadata    map[string]string
+	lastRenewal time.Time
+}
+
+type change struct {
+	at       time.Time
+	instance *fargo.Instance
+}
+
+// A Server is a fake Eureka server that holds its registry in memory. It implements the parts of
+// the Eureka REST API that fargo uses, in both XML and JSON: registration, heartbeats,
+// deregistration, status and metadata updates, and queries for applications, instances, and VIP
+// addresses, including the registry delta.
+//
+// Like a real Eureka server, it evicts instances whose leases expire without being renewed by a
+// heartbeat. Its clock starts at the current time, and can be advanced with Advance to expire
+// leases without waiting.
+type Server struct {
+	// URL is the service URL at which to reach the server, suitable for use with fargo.NewConn.
+	URL string
+
+	server  *httptest.Server
+	m       sync.Mutex
+	offset  time.Duration
+	apps    map[string]map[string]*lease
+	changes []change
+	version int
+	latency time.Duration
+	fail    func(r *http.Request) int
+}
+
+// NewServer starts and returns a new Server with an empty registry. The caller should call Close
+// when finished, to shut it down.
+func NewServer() *Server {
+	s := &Server{
+		apps: make(map[string]map[string]*lease),
+	}
+	s.server = httptest.NewServer(http.StripPrefix(BasePath, http.HandlerFunc(s.serveHTTP)))
+	s.URL = s.server.URL + BasePath
+	return s
+}
+
+// Close shuts down the server, blocking until all outstanding requests have completed.
+func (s *Server) Close() {
+	s.server.Close()
+}
+
+// SetLatency delays the server's response to each subsequent request by the given duration.
+func (s *Server) SetLatency(d time.Duration) {
+	s.m.Lock()
+	defer s.m.Unlock()
+	s.latency = d
+}
+
+// SetFailureHook arranges for the server to consult the given function before handling each
+// subsequent request. If the function returns a nonzero HTTP status code, the server responds
+// with that status code rather than handling the request. If f is nil, the server handles every
+// request.
+func (s *Server) SetFailureHook(f func(r *http.Request) int) {
+	s.m.Lock()
+	defer s.m.Unlock()
+	s.fail = f
+}
+
+// Advance moves the server's clock forward by the given duration, evicting any instances whose
+// leases expire in the meantime.
+func (s *Server) Advance(d time.Duration) {
+	s.m.Lock()
+	defer s.m.Unlock()
+	s.offset += d
+	s.evictExpired()
+}
+
+// Register adds the given instance to the registry directly, as though it had registered itself.
+func (s *Server) Register(ins *fargo.Instance) {
+	s.m.Lock()
+	defer s.m.Unlock()
+	s.register(ins)
+}
+
+// Instances returns copies of the registered instances of the application with the given name,
+// in order by instance ID.
+func (s *Server) Instances(app string) []*fargo.Instance {
+	s.m.Lock()
+	defer s.m.Unlock()
+	s.evictExpired()
+	return s.instancesOf(strings.ToUpper(app))
+}
+
+func (s *Server) now() time.Time {
+	return time.Now().Add(s.offset)
+}
+
+func millis(t time.Time) int64 {
+	return t.UnixNano() / int64(time.Millisecond)
+}
+
+func leaseDuration(ins *fargo.Instance) time.Duration {
+	if secs := ins.LeaseInfo.DurationInSecs; secs > 0 {
+		return time.Duration(secs) * time.Second
+	}
+	return defaultLeaseDuration
+}
+
+// metadataOf extracts the metadata of the given instance as string values.
+func metadataOf(ins *fargo.Instance) map[string]string {
+	m := make(map[string]string)
+	// Parsing would discard metadata set directly on an instance rather than decoded with it.
+	if ins.Metadata.GetMap() == nil {
+		app := fargo.Application{Instances: []*fargo.Instance{ins}}
+		if err := app.ParseAllMetadata(); err != nil {
+			return m
+		}
+	}
+	for k, v := range ins.Metadata.GetMap() {
+		m[k] = fmt.Sprint(v)
+	}
+	return m
+}
+
+// snapshot returns a copy of the leased instance, fit for encoding.
+func (l *lease) snapshot() *fargo.Instance {
+	ins := l.instance
+	ins.Metadata = fargo.InstanceMetadata{}
+	for k, v := range l.metadata {
+		ins.SetMetadataString(k, v)
+	}
+	return &ins
+}
+
+func (s *Server) record(action fargo.ActionType, l *lease) {
+	ins := l.snapshot()
+	ins.ActionType = action
+	s.changes = append(s.changes, change{s.now(), ins})
+	s.version++
+}
+
+func (s *Server) register(ins *fargo.Instance) {
+	app := strings.ToUpper(ins.App)
+	instances, ok := s.apps[app]
+	if !ok {
+		instances = make(map[string]*lease)
+		s.apps[app] = instances
+	}
+	now := s.now()
+	l := &lease{
+		instance:    *ins,
+		metadata:    metadataOf(ins),
+		lastRenewal: now,
+	}
+	l.instance.App = app
+	if len(l.instance.Status) == 0 {
+		l.instance.Status = fargo.UP
+	}
+	if len(l.instance.Overriddenstatus) == 0 {
+		l.instance.Overriddenstatus = fargo.UNKNOWN
+	}
+	l.instance.LeaseInfo.RegistrationTimestamp = millis(now)
+	l.instance.LeaseInfo.LastRenewalTimestamp = millis(now)
+	action := fargo.ADDED
+	if _, ok := instances[ins.Id()]; ok {
+		action = fargo.MODIFIED
+	}
+	instances[ins.Id()] = l
+	s.record(action, l)
+}
+
+func (s *Server) evictExpired() {
+	now := s.now()
+	for app, instances := range s.apps {
+		for id, l := range instances {
+			if now.Sub(l.lastRenewal) > leaseDuration(&l.instance) {
+				delete(instances, id)
+				s.record(fargo.DELETED, l)
+			}
+		}
+		if len(instances) == 0 {
+			delete(s.apps, app)
+		}
+	}
+	cutoff := now.Add(-deltaRetention)
+	i := 0
+	for i < len(s.changes) && s.changes[i].at.Before(cutoff) {
+		i++
+	}
+	s.changes = s.changes[i:]
+}
+
+func (s *Server) lookup(app, id string) (*lease, bool) {
+	l, ok := s.apps[strings.ToUpper(app)][id]
+	return l, ok
+}
+
+func (s *Server) instancesOf(app string) []*fargo.Instance {
+	instances := s.apps[app]
+	ids := make([]string, 0, len(instances))
+	for id := range instances {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	result := make([]*fargo.Instance, len(ids))
+	for i, id := range ids {
+		result[i] = instances[id].snapshot()
+	}
+	return result
+}
+
+func (s *Server) application(app string) *fargo.Application {
+	return &fargo.Application{Name: app, Instances: s.instancesOf(app)}
+}
+
+func (s *Server) appNames() []string {
+	names := make([]string, 0, len(s.apps))
+	for name := range s.apps {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// hashCode computes the registry's hash code the way the Eureka server does.
+func hashCode(instances []*fargo.Instance) string {
+	counts := make(map[fargo.StatusType]int)
+	for _, ins := range instances {
+		counts[ins.Status]++
+	}
+	statuses := make([]string, 0, len(counts))
+	for status := range counts {
+		statuses = append(statuses, string(status))
+	}
+	sort.Strings(statuses)
+	var b strings.Builder
+	for _, status := range statuses {
+		fmt.Fprintf(&b, "%s_%d_", status, counts[fargo.StatusType(status)])
+	}
+	return b.String()
+}
+
+func (s *Server) appsResponse(include func(*fargo.Instance) bool) *fargo.GetAppsResponse {
+	r := &fargo.GetAppsResponse{VersionsDelta: s.version}
+	var all []*fargo.Instance
+	for _, name := range s.appNames() {
+		app := s.application(name)
+		all = append(all, app.Instances...)
+		var instances []*fargo.Instance
+		for _, ins := range app.Instances {
+			if include(ins) {
+				instances = append(instances, ins)
+			}
+		}
+		if len(instances) > 0 {
+			app.Instances = instances
+			r.Applications = append(r.Applications, app)
+		}
+	}
+	r.AppsHashcode = hashCode(all)
+	return r
+}
+
+func (s *Server) deltaResponse() *fargo.GetAppsResponse {
+	r := s.appsResponse(func(*fargo.Instance) bool { return false })
+	byApp := make(map[string]*fargo.Application)
+	for _, c := range s.changes {
+		app, ok := byApp[c.instance.App]
+		if !ok {
+			app = &fargo.Application{Name: c.instance.App}
+			byApp[c.instance.App] = app
+			r.Applications = append(r.Applications, app)
+		}
+		app.Instances = append(app.Instances, c.instance)
+	}
+	return r
+}
+
+func vipAddressIncludes(addresses, addr string) bool {
+	for _, a := range strings.Split(addresses, ",") {
+		if strings.TrimSpace(a) == addr {
+			return true
+		}
+	}
+	return false
+}
+
+type applicationsXML struct {
+	XMLName xml.Name `xml:"applications"`
+	*fargo.GetAppsResponse
+}
+
+type applicationXML struct {
+	XMLName xml.Name `xml:"application"`
+	*fargo.Application
+}
+
+func wantsJSON(r *http.Request) bool {
+	return strings.Contains(r.Header.Get("Accept"), "json")
+}
+
+func writeApps(w http.ResponseWriter, r *http.Request, apps *fargo.GetAppsResponse) {
+	if wantsJSON(r) {
+		writeJSON(w, &fargo.GetAppsResponseJson{Response: apps})
+		return
+	}
+	writeXML(w, &applicationsXML{GetAppsResponse: apps})
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(b)
+}
+
+func writeXML(w http.ResponseWriter, v interface{}) {
+	b, err := xml.Marshal(v)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/xml")
+	w.Write(b)
+}
+
+func readInstance(r *http.Request) (*fargo.Instance, error) {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return nil, err
+	}
+	if strings.Contains(r.Header.Get("Content-Type"), "json") {
+		var ij fargo.RegisterInstanceJson
+		if err := json.Unmarshal(body, &ij); err != nil {
+			return nil, err
+		}
+		if ij.Instance == nil {
+			return nil, fmt.Errorf("no instance in request body")
+		}
+		return ij.Instance, nil
+	}
+	var ins fargo.Instance
+	if err := xml.Unmarshal(body, &ins); err != nil {
+		return nil, err
+	}
+	return &ins, nil
+}
+
+func (s *Server) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	s.m.Lock()
+	latency, fail := s.latency, s.fail
+	s.m.Unlock()
+	if latency > 0 {
+		select {
+		case <-time.After(latency):
+		case <-r.Context().Done():
+			return
+		}
+	}
+	if fail != nil {
+		if code := fail(r); code != 0 {
+			w.WriteHeader(code)
+			return
+		}
+	}
+
+	s.m.Lock()
+	defer s.m.Unlock()
+	s.evictExpired()
+	segments := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	switch {
+	case len(segments) == 1 && segments[0] == "apps" && r.Method == "GET":
+		writeApps(w, r, s.appsResponse(func(*fargo.Instance) bool { return true }))
+	case len(segments) == 2 && segments[0] == "apps" && segments[1] == "delta" && r.Method == "GET":
+		writeApps(w, r, s.deltaResponse())
+	case len(segments) == 2 && (segments[0] == "vips" || segments[0] == "svips") && r.Method == "GET":
+		secure := segments[0] == "svips"
+		writeApps(w, r, s.appsResponse(func(ins *fargo.Instance) bool {
+			if secure {
+				return vipAddressIncludes(ins.SecureVipAddress, segments[1])
+			}
+			return vipAddressIncludes(ins.VipAddress, segments[1])
+		}))
+	case len(segments) == 2 && segments[0] == "apps":
+		s.serveApp(w, r, strings.ToUpper(segments[1]))
+	case len(segments) == 3 && segments[0] == "apps":
+		s.serveInstance(w, r, segments[1], segments[2])
+	case len(segments) == 4 && segments[0] == "apps" && segments[3] == "status" && r.Method == "PUT":
+		s.updateInstance(w, r, segments[1], segments[2], func(l *lease) {
+			status := fargo.StatusType(r.URL.Query().Get("value"))
+			l.instance.Status = status
+			l.instance.Overriddenstatus = status
+		})
+	case len(segments) == 4 && segments[0] == "apps" && segments[3] == "status" && r.Method == "DELETE":
+		s.updateInstance(w, r, segments[1], segments[2], func(l *lease) {
+			if status := fargo.StatusType(r.URL.Query().Get("value")); len(status) > 0 {
+				l.instance.Status = status
+			}
+			l.instance.Overriddenstatus = fargo.UNKNOWN
+		})
+	case len(segments) == 4 && segments[0] == "apps" && segments[3] == "metadata" && r.Method == "PUT":
+		s.updateInstance(w, r, segments[1], segments[2], func(l *lease) {
+			for k, v := range r.URL.Query() {
+				if len(v) > 0 {
+					l.metadata[k] = v[0]
+				}
+			}
+		})
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func (s *Server) serveApp(w http.ResponseWriter, r *http.Request, app string) {
+	switch r.Method {
+	case "GET":
+		if _, ok := s.apps[app]; !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		a := s.application(app)
+		if wantsJSON(r) {
+			writeJSON(w, &fargo.GetAppResponseJson{Application: *a})
+			return
+		}
+		writeXML(w, &applicationXML{Application: a})
+	case "POST":
+		ins, err := readInstance(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if !strings.EqualFold(ins.App, app) {
+			http.Error(w, "application name mismatch", http.StatusBadRequest)
+			return
+		}
+		s.register(ins)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func (s *Server) serveInstance(w http.ResponseWriter, r *http.Request, app, id string) {
+	l, ok := s.lookup(app, id)
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	switch r.Method {
+	case "GET":
+		ins := l.snapshot()
+		if wantsJSON(r) {
+			writeJSON(w, &fargo.RegisterInstanceJson{Instance: ins})
+			return
+		}
+		writeXML(w, ins)
+	case "PUT":
+		l.lastRenewal = s.now()
+		l.instance.LeaseInfo.LastRenewalTimestamp = millis(l.lastRenewal)
+		w.WriteHeader(http.StatusOK)
+	case "DELETE":
+		delete(s.apps[strings.ToUpper(app)], id)
+		if len(s.apps[strings.ToUpper(app)]) == 0 {
+			delete(s.apps, strings.ToUpper(app))
+		}
+		s.record(fargo.DELETED, l)
+		w.WriteHeader(http.StatusOK)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func (s *Server) updateInstance(w http.ResponseWriter, r *http.Request, app, id string, update func(*lease)) {
+	l, ok := s.lookup(app, id)
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	update(l)
+	s.record(fargo.MODIFIED, l)
+	w.WriteHeader(http.StatusOK)
+}
@@ -0,0 +1,137 @@
+package fargotest
+
+// MIT Licensed (see README.md) - Copyright (c) 2013 Hudl <@Hudl>
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/hudl/fargo"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func testInstance(id string) *fargo.Instance {
+	return &fargo.Instance{
+		InstanceId:     id,
+		HostName:       id + ".example.com",
+		App:            "TESTAPP",
+		IPAddr:         "10.0.0.1",
+		VipAddress:     "testapp,testapp-canary",
+		Status:         fargo.UP,
+		Port:           8080,
+		PortEnabled:    true,
+		DataCenterInfo: fargo.DataCenterInfo{Name: fargo.MyOwn},
+		LeaseInfo:      fargo.LeaseInfo{DurationInSecs: 30},
+	}
+}
+
+func TestServer(t *testing.T) {
+	for _, useJson := range []bool{false, true} {
+		Convey("Given a fake Eureka server", t, func() {
+			s := NewServer()
+			defer s.Close()
+			e := fargo.NewConn(s.URL)
+			e.UseJson = useJson
+			e.Logger = fargo.NoopLogger{}
+
+			Convey("an instance can register itself", func() {
+				ins := testInstance("i-1")
+				ins.SetMetadataString("color", "blue")
+				So(e.RegisterInstance(ins), ShouldBeNil)
+				So(s.Instances("testapp"), ShouldHaveLength, 1)
+
+				Convey("and be found by application", func() {
+					app, err := e.GetApp("TESTAPP")
+					So(err, ShouldBeNil)
+					So(app.Instances, ShouldHaveLength, 1)
+					So(app.Instances[0].HostName, ShouldEqual, "i-1.example.com")
+					So(app.Instances[0].Port, ShouldEqual, 8080)
+					color, err := app.Instances[0].Metadata.GetString("color")
+					So(err, ShouldBeNil)
+					So(color, ShouldEqual, "blue")
+				})
+
+				Convey("and be found by VIP address", func() {
+					instances, err := e.GetInstancesByVIPAddress("testapp-canary", false)
+					So(err, ShouldBeNil)
+					So(instances, ShouldHaveLength, 1)
+					instances, err = e.GetInstancesByVIPAddress("other", false)
+					So(err, ShouldBeNil)
+					So(instances, ShouldBeEmpty)
+				})
+
+				Convey("and update its status and metadata", func() {
+					So(e.UpdateInstanceStatus(ins, fargo.OUTOFSERVICE), ShouldBeNil)
+					So(e.AddMetadataString(ins, "color", "green"), ShouldBeNil)
+					found, err := e.GetInstance("TESTAPP", "i-1")
+					So(err, ShouldBeNil)
+					So(found.Status, ShouldEqual, fargo.OUTOFSERVICE)
+					color, err := found.Metadata.GetString("color")
+					So(err, ShouldBeNil)
+					So(color, ShouldEqual, "green")
+				})
+
+				Convey("and keep its lease with heartbeats", func() {
+					s.Advance(20 * time.Second)
+					So(e.HeartBeatInstance(ins), ShouldBeNil)
+					s.Advance(20 * time.Second)
+					So(s.Instances("TESTAPP"), ShouldHaveLength, 1)
+
+					Convey("but lose it without them", func() {
+						s.Advance(time.Minute)
+						So(s.Instances("TESTAPP"), ShouldBeEmpty)
+						err := e.HeartBeatInstance(ins)
+						code, ok := fargo.HTTPResponseStatusCode(err)
+						So(ok, ShouldBeTrue)
+						So(code, ShouldEqual, http.StatusNotFound)
+						_, err = e.GetApp("TESTAPP")
+						So(err, ShouldHaveSameTypeAs, fargo.AppNotFoundError{})
+					})
+				})
+
+				Convey("and deregister itself", func() {
+					So(e.DeregisterInstance(ins), ShouldBeNil)
+					So(s.Instances("TESTAPP"), ShouldBeEmpty)
+				})
+
+				Convey("and be tracked incrementally via the registry delta", func() {
+					e.EnableDelta = true
+					r := e.NewRegistry()
+					So(r.Refresh(), ShouldBeNil)
+					s.Register(testInstance("i-2"))
+					So(e.DeregisterInstance(ins), ShouldBeNil)
+					So(r.Refresh(), ShouldBeNil)
+					apps := r.Apps()
+					So(apps, ShouldContainKey, "TESTAPP")
+					So(apps["TESTAPP"].Instances, ShouldHaveLength, 1)
+					So(apps["TESTAPP"].Instances[0].Id(), ShouldEqual, "i-2")
+				})
+			})
+
+			Convey("requests fail as directed by the failure hook", func() {
+				s.SetFailureHook(func(r *http.Request) int {
+					if r.Method == "GET" {
+						return http.StatusServiceUnavailable
+					}
+					return 0
+				})
+				e.Retries = -1
+				s.Register(testInstance("i-1"))
+				_, err := e.GetInstance("TESTAPP", "i-1")
+				code, ok := fargo.HTTPResponseStatusCode(err)
+				So(ok, ShouldBeTrue)
+				So(code, ShouldEqual, http.StatusServiceUnavailable)
+				So(e.HeartBeatInstance(testInstance("i-1")), ShouldBeNil)
+			})
+
+			Convey("requests are delayed by the configured latency", func() {
+				s.SetLatency(time.Second)
+				e.Timeout = 10 * time.Millisecond
+				e.Retries = -1
+				_, err := e.GetApps()
+				So(err, ShouldNotBeNil)
+			})
+		})
+	}
+}
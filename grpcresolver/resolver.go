@@ -0,0 +1,216 @@
+// Package grpcresolver provides a gRPC name resolver that discovers servers registered with
+// Eureka under a VIP address.
+//
+// Register the resolver's builder with a gRPC client, and address targets as
+// "eureka:///vip-address":
+//
+//	conn, err := grpc.NewClient("eureka:///my-service",
+//		grpc.WithResolvers(grpcresolver.NewBuilder(e)),
+//		grpc.WithTransportCredentials(insecure.NewCredentials()))
+package grpcresolver
+
+// MIT Licensed (see README.md) - Copyright (c) 2013 Hudl <@Hudl>
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"reflect"
+	"strconv"
+	"sync"
+	"sync/atomic"
+
+	"github.com/hudl/fargo"
+	"google.golang.org/grpc/attributes"
+	"google.golang.org/grpc/resolver"
+)
+
+// Scheme is the gRPC target scheme handled by the resolver.
+const Scheme = "eureka"
+
+type options struct {
+	secure bool
+	useIP  bool
+}
+
+// Option customizes how the resolver discovers and addresses instances.
+type Option func(*options)
+
+// UsingSecureVIPAddress directs the resolver to look up instances by their secure VIP address,
+// addressing them at their secure port. By default, it looks up instances by their insecure VIP
+// address, addressing them at their insecure port.
+func UsingSecureVIPAddress(o *options) {
+	o.secure = true
+}
+
+// UsingIPAddress directs the resolver to address instances by their IP address rather than their
+// host name.
+func UsingIPAddress(o *options) {
+	o.useIP = true
+}
+
+type builder struct {
+	conn *fargo.EurekaConnection
+	opts options
+}
+
+// NewBuilder returns a gRPC resolver builder for the "eureka" scheme that resolves each target's
+// endpoint as a VIP address, offering the UP instances registered with that address via the given
+// connection. Each resolver follows the changes to its instances as the connection polls Eureka,
+// per the connection's PollInterval.
+func NewBuilder(e *fargo.EurekaConnection, opts ...Option) resolver.Builder {
+	b := &builder{conn: e}
+	for _, o := range opts {
+		if o != nil {
+			o(&b.opts)
+		}
+	}
+	return b
+}
+
+func (b *builder) Scheme() string {
+	return Scheme
+}
+
+func (b *builder) Build(target resolver.Target, cc resolver.ClientConn, _ resolver.BuildOptions) (resolver.Resolver, error) {
+	vip := target.Endpoint()
+	if len(vip) == 0 {
+		return nil, errors.New("target lacks a VIP address")
+	}
+	r := &eurekaResolver{
+		cc:   cc,
+		vip:  vip,
+		opts: b.opts,
+	}
+	// Acquiring the first set of instances may take a while, so don't hold up the caller.
+	go r.start(b.conn)
+	return r, nil
+}
+
+type eurekaResolver struct {
+	cc   resolver.ClientConn
+	vip  string
+	opts options
+	// m guards the following fields, which start assigns and Close tears down.
+	m           sync.Mutex
+	closed      bool
+	source      *fargo.InstanceSetSource
+	unsubscribe func()
+}
+
+func (r *eurekaResolver) start(e *fargo.EurekaConnection) {
+	source, err := e.NewInstanceSetSourceForVIPAddress(r.vip, r.opts.secure, true, fargo.ThatAreUp)
+	if err != nil {
+		r.cc.ReportError(err)
+		return
+	}
+	r.m.Lock()
+	defer r.m.Unlock()
+	if r.closed {
+		source.Stop()
+		return
+	}
+	r.source = source
+	// The source only notifies subscribers of changes, so push the first, possibly empty, set of
+	// instances unless subscribing already did so.
+	var notified int32
+	r.unsubscribe = source.Subscribe(func([]fargo.InstanceChange) {
+		atomic.StoreInt32(&notified, 1)
+		r.update(source.Latest())
+	})
+	if atomic.LoadInt32(&notified) == 0 {
+		r.update(source.Latest())
+	}
+}
+
+func (r *eurekaResolver) address(ins *fargo.Instance) (string, bool) {
+	port, enabled := ins.Port, ins.PortEnabled
+	if r.opts.secure {
+		port, enabled = ins.SecurePort, ins.SecurePortEnabled
+	}
+	if !enabled {
+		return "", false
+	}
+	host := ins.HostName
+	if r.opts.useIP || len(host) == 0 {
+		host = ins.IPAddr
+	}
+	if len(host) == 0 {
+		return "", false
+	}
+	return net.JoinHostPort(host, strconv.Itoa(port)), true
+}
+
+// update pushes the addresses of the given instances to the client connection, or reports an error
+// if there are none.
+func (r *eurekaResolver) update(instances []*fargo.Instance) {
+	if instances == nil {
+		r.cc.ReportError(fmt.Errorf("failed to acquire instances for VIP address %q", r.vip))
+		return
+	}
+	addrs := make([]resolver.Address, 0, len(instances))
+	for _, ins := range instances {
+		addr, ok := r.address(ins)
+		if !ok {
+			continue
+		}
+		addrs = append(addrs, resolver.Address{
+			Addr: addr,
+			Attributes: attributes.New(instanceKey{}, instance{
+				id:       ins.Id(),
+				metadata: ins.Metadata.GetMap(),
+			}),
+		})
+	}
+	if len(addrs) == 0 {
+		r.cc.ReportError(fmt.Errorf("no instances available for VIP address %q", r.vip))
+		return
+	}
+	r.cc.UpdateState(resolver.State{Addresses: addrs})
+}
+
+// ResolveNow does nothing, as the resolver already polls Eureka for changes.
+func (r *eurekaResolver) ResolveNow(resolver.ResolveNowOptions) {}
+
+func (r *eurekaResolver) Close() {
+	r.m.Lock()
+	defer r.m.Unlock()
+	r.closed = true
+	if r.source != nil {
+		r.unsubscribe()
+		r.source.Stop()
+	}
+}
+
+type instanceKey struct{}
+
+// instance identifies the Eureka instance behind a resolved address.
+type instance struct {
+	id       string
+	metadata map[string]interface{}
+}
+
+// Equal allows gRPC to compare addresses' attributes, which it can't do with ==, given the map.
+func (i instance) Equal(o interface{}) bool {
+	other, ok := o.(instance)
+	return ok && i.id == other.id && reflect.DeepEqual(i.metadata, other.metadata)
+}
+
+func instanceOf(addr resolver.Address) (instance, bool) {
+	i, ok := addr.Attributes.Value(instanceKey{}).(instance)
+	return i, ok
+}
+
+// InstanceID returns the ID of the Eureka instance behind an address produced by the resolver, or
+// an empty string for other addresses.
+func InstanceID(addr resolver.Address) string {
+	i, _ := instanceOf(addr)
+	return i.id
+}
+
+// Metadata returns the metadata of the Eureka instance behind an address produced by the resolver,
+// or nil for other addresses. Callers must not modify the returned map.
+func Metadata(addr resolver.Address) map[string]interface{} {
+	i, _ := instanceOf(addr)
+	return i.metadata
+}
@@ -0,0 +1,176 @@
+package grpcresolver
+
+// MIT Licensed (see README.md) - Copyright (c) 2013 Hudl <@Hudl>
+
+import (
+	"context"
+	"net"
+	"net/url"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hudl/fargo"
+	"github.com/hudl/fargo/fargotest"
+	. "github.com/smartystreets/goconvey/convey"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/resolver"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+func testInstance(id string, status fargo.StatusType) *fargo.Instance {
+	ins := &fargo.Instance{
+		InstanceId:     id,
+		HostName:       id + ".example.com",
+		App:            "TESTAPP",
+		IPAddr:         "10.0.0.1",
+		VipAddress:     "testsvc",
+		Status:         status,
+		Port:           8080,
+		PortEnabled:    true,
+		DataCenterInfo: fargo.DataCenterInfo{Name: fargo.MyOwn},
+		LeaseInfo:      fargo.LeaseInfo{DurationInSecs: 30},
+	}
+	ins.SetMetadataString("zone", "a")
+	return ins
+}
+
+// recordingClientConn collects the updates a resolver pushes to it.
+type recordingClientConn struct {
+	resolver.ClientConn
+	m      sync.Mutex
+	states []resolver.State
+	errs   []error
+}
+
+func (cc *recordingClientConn) UpdateState(s resolver.State) error {
+	cc.m.Lock()
+	defer cc.m.Unlock()
+	cc.states = append(cc.states, s)
+	return nil
+}
+
+func (cc *recordingClientConn) ReportError(err error) {
+	cc.m.Lock()
+	defer cc.m.Unlock()
+	cc.errs = append(cc.errs, err)
+}
+
+func (cc *recordingClientConn) latest() ([]string, error) {
+	cc.m.Lock()
+	defer cc.m.Unlock()
+	var err error
+	if len(cc.errs) > 0 {
+		err = cc.errs[len(cc.errs)-1]
+	}
+	if len(cc.states) == 0 {
+		return nil, err
+	}
+	var addrs []string
+	for _, a := range cc.states[len(cc.states)-1].Addresses {
+		addrs = append(addrs, a.Addr)
+	}
+	sort.Strings(addrs)
+	return addrs, err
+}
+
+// eventually polls f until it returns true, giving up after a few seconds.
+func eventually(f func() bool) bool {
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(5 * time.Millisecond) {
+		if f() {
+			return true
+		}
+	}
+	return false
+}
+
+func TestResolver(t *testing.T) {
+	Convey("Given a fake Eureka server with instances behind a VIP address", t, func() {
+		s := fargotest.NewServer()
+		defer s.Close()
+		s.Register(testInstance("i-1", fargo.UP))
+		s.Register(testInstance("i-2", fargo.DOWN))
+		e := fargo.NewConn(s.URL)
+		e.Logger = fargo.NoopLogger{}
+		e.PollInterval = 10 * time.Millisecond
+		b := NewBuilder(&e)
+
+		Convey("a resolver pushes the addresses of the UP instances", func() {
+			cc := &recordingClientConn{}
+			r, err := b.Build(resolver.Target{URL: url.URL{Scheme: Scheme, Path: "/testsvc"}}, cc, resolver.BuildOptions{})
+			So(err, ShouldBeNil)
+			defer r.Close()
+			So(eventually(func() bool {
+				addrs, _ := cc.latest()
+				return len(addrs) == 1
+			}), ShouldBeTrue)
+			cc.m.Lock()
+			addr := cc.states[0].Addresses[0]
+			cc.m.Unlock()
+			So(addr.Addr, ShouldEqual, "i-1.example.com:8080")
+			So(InstanceID(addr), ShouldEqual, "i-1")
+			So(Metadata(addr), ShouldResemble, map[string]interface{}{"zone": "a"})
+
+			Convey("following the instances as they come and go", func() {
+				s.Register(testInstance("i-3", fargo.UP))
+				So(eventually(func() bool {
+					addrs, _ := cc.latest()
+					return len(addrs) == 2
+				}), ShouldBeTrue)
+				addrs, _ := cc.latest()
+				So(addrs, ShouldResemble, []string{"i-1.example.com:8080", "i-3.example.com:8080"})
+			})
+		})
+
+		Convey("a resolver reports an error when no instances are UP", func() {
+			cc := &recordingClientConn{}
+			r, err := NewBuilder(&e, UsingSecureVIPAddress).Build(resolver.Target{URL: url.URL{Scheme: Scheme, Path: "/testsvc"}}, cc, resolver.BuildOptions{})
+			So(err, ShouldBeNil)
+			defer r.Close()
+			So(eventually(func() bool {
+				_, err := cc.latest()
+				return err != nil
+			}), ShouldBeTrue)
+		})
+
+		Convey("a target must name a VIP address", func() {
+			_, err := b.Build(resolver.Target{URL: url.URL{Scheme: Scheme}}, &recordingClientConn{}, resolver.BuildOptions{})
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("a gRPC client can reach a server by its VIP address", func() {
+			lis := bufconn.Listen(1 << 20)
+			server := grpc.NewServer()
+			healthpb.RegisterHealthServer(server, health.NewServer())
+			go server.Serve(lis)
+			defer server.Stop()
+
+			var m sync.Mutex
+			var dialed []string
+			conn, err := grpc.NewClient(Scheme+":///testsvc",
+				grpc.WithResolvers(b),
+				grpc.WithTransportCredentials(insecure.NewCredentials()),
+				grpc.WithContextDialer(func(ctx context.Context, addr string) (net.Conn, error) {
+					m.Lock()
+					dialed = append(dialed, addr)
+					m.Unlock()
+					return lis.DialContext(ctx)
+				}))
+			So(err, ShouldBeNil)
+			defer conn.Close()
+
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			resp, err := healthpb.NewHealthClient(conn).Check(ctx, &healthpb.HealthCheckRequest{}, grpc.WaitForReady(true))
+			So(err, ShouldBeNil)
+			So(resp.Status, ShouldEqual, healthpb.HealthCheckResponse_SERVING)
+			m.Lock()
+			defer m.Unlock()
+			So(dialed, ShouldContain, "i-1.example.com:8080")
+		})
+	})
+}
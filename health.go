@@ -0,0 +1,340 @@
+package fargo
+
+// MIT Licensed (see README.md) - Copyright (c) 2013 Hudl <@Hudl>
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	defaultHealthCheckInterval  = 10 * time.Second
+	defaultHealthCheckThreshold = 3
+)
+
+// ErrOutOfService may be returned by a HealthCheck, possibly wrapped, to indicate that the instance
+// is healthy but should not receive traffic, such as while it drains its work before shutting
+// down.
+var ErrOutOfService = errors.New("instance is out of service")
+
+// A HealthCheck inspects some facet of a service, such as its connection to a database, returning
+// an error if that facet is unhealthy. It should return promptly once the supplied context is done.
+type HealthCheck func(ctx context.Context) error
+
+type healthReporterOptions struct {
+	interval  time.Duration
+	timeout   time.Duration
+	threshold int
+}
+
+// HealthReporterOption customizes how a HealthReporter runs its checks.
+type HealthReporterOption func(*healthReporterOptions) error
+
+// HealthCheckInterval sets the period at which a HealthReporter runs its checks. By default, it
+// runs them every ten seconds.
+func HealthCheckInterval(d time.Duration) HealthReporterOption {
+	return func(o *healthReporterOptions) error {
+		if d <= 0 {
+			return fmt.Errorf("health check interval must be positive; got %v", d)
+		}
+		o.interval = d
+		return nil
+	}
+}
+
+// HealthCheckTimeout sets the time allowed for each check to run before the HealthReporter
+// considers it failed. By default, checks may run for as long as the interval between them.
+func HealthCheckTimeout(d time.Duration) HealthReporterOption {
+	return func(o *healthReporterOptions) error {
+		if d <= 0 {
+			return fmt.Errorf("health check timeout must be positive; got %v", d)
+		}
+		o.timeout = d
+		return nil
+	}
+}
+
+// HealthCheckThreshold sets the number of consecutive rounds of checks that must yield the same
+// status before a HealthReporter reports that status to Eureka, so as to keep an intermittently
+// failing check from flapping the instance's status. By default, it requires three rounds.
+func HealthCheckThreshold(n int) HealthReporterOption {
+	return func(o *healthReporterOptions) error {
+		if n < 1 {
+			return fmt.Errorf("health check threshold must be positive; got %d", n)
+		}
+		o.threshold = n
+		return nil
+	}
+}
+
+// A HealthReporter runs a set of health checks periodically, setting the status of an instance
+// registered with Eureka per their results: UP when all pass, OUT_OF_SERVICE when any return
+// ErrOutOfService and the rest pass, and DOWN when any fail otherwise.
+//
+// It sets the status via UpdateInstanceStatus, which overrides the status the instance reports
+// when registering. It leaves alone an override set by others, such as an OUT_OF_SERVICE status
+// set by an operator via SetStatusOverride, resuming once that override is removed. It does not
+// modify the supplied Instance.
+type HealthReporter struct {
+	conn     *EurekaConnection
+	instance Instance
+	checks   map[string]HealthCheck
+	opts     healthReporterOptions
+	// candidate is the status yielded by the most recent rounds of checks, streak times running.
+	candidate StatusType
+	streak    int
+	// overridden is the status with which others last overrode the instance's, if any.
+	overridden StatusType
+	// m guards the following fields, which the handlers read.
+	m        sync.RWMutex
+	reported StatusType
+	results  map[string]error
+	errs     chan error
+	done     chan struct{}
+	finished chan struct{}
+	stopOnce sync.Once
+}
+
+// NewHealthReporter returns a HealthReporter that runs the given named checks for the given
+// instance, first immediately and then at the configured interval, until either its Stop method
+// is called or the supplied context is done. The instance must already be registered with Eureka,
+// such as by a Registrar.
+//
+// A change in status takes effect once the checks yield the new status for the configured number
+// of consecutive rounds, except that while the instance's status is STARTING or UNKNOWN, the first
+// round's status takes effect immediately.
+//
+// It returns an error if any of the supplied options are invalid.
+func (e *EurekaConnection) NewHealthReporter(ctx context.Context, ins *Instance, checks map[string]HealthCheck, opts ...HealthReporterOption) (*HealthReporter, error) {
+	h := &HealthReporter{
+		conn:     e,
+		instance: *ins,
+		checks:   make(map[string]HealthCheck, len(checks)),
+		opts: healthReporterOptions{
+			interval:  defaultHealthCheckInterval,
+			threshold: defaultHealthCheckThreshold,
+		},
+		reported: ins.Status,
+		errs:     make(chan error, 1),
+		done:     make(chan struct{}),
+		finished: make(chan struct{}),
+	}
+	for _, o := range opts {
+		if o == nil {
+			continue
+		}
+		if err := o(&h.opts); err != nil {
+			return nil, err
+		}
+	}
+	if h.opts.timeout == 0 {
+		h.opts.timeout = h.opts.interval
+	}
+	for name, c := range checks {
+		h.checks[name] = c
+	}
+	go h.run(ctx)
+	return h, nil
+}
+
+func (h *HealthReporter) run(ctx context.Context) {
+	defer close(h.finished)
+	defer close(h.errs)
+	t := time.NewTicker(h.opts.interval)
+	defer t.Stop()
+	for {
+		h.check(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-h.done:
+			return
+		case <-t.C:
+		}
+	}
+}
+
+func runHealthCheck(ctx context.Context, c HealthCheck) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("health check panicked: %v", r)
+		}
+	}()
+	return c(ctx)
+}
+
+// statusFor returns the status indicated by the given check results.
+func statusFor(results map[string]error) StatusType {
+	status := UP
+	for _, err := range results {
+		switch {
+		case err == nil:
+		case errors.Is(err, ErrOutOfService):
+			if status == UP {
+				status = OUTOFSERVICE
+			}
+		default:
+			return DOWN
+		}
+	}
+	return status
+}
+
+// check runs a round of checks, reporting the resulting status to Eureka if warranted.
+func (h *HealthReporter) check(ctx context.Context) {
+	results := make(map[string]error, len(h.checks))
+	for name, c := range h.checks {
+		checkCtx, cancel := context.WithTimeout(ctx, h.opts.timeout)
+		results[name] = runHealthCheck(checkCtx, c)
+		cancel()
+	}
+	status := statusFor(results)
+	h.m.Lock()
+	h.results = results
+	reported := h.reported
+	h.m.Unlock()
+
+	if status == h.candidate {
+		h.streak++
+	} else {
+		h.candidate = status
+		h.streak = 1
+	}
+	if status == reported && len(h.overridden) == 0 {
+		return
+	}
+	if h.streak < h.opts.threshold && reported != STARTING && reported != UNKNOWN && len(reported) > 0 {
+		return
+	}
+	current, err := h.conn.GetInstanceContext(ctx, h.instance.App, h.instance.Id())
+	if err != nil {
+		// Try again after the next round.
+		h.report(err)
+		return
+	}
+	if o := current.Overriddenstatus; len(o) > 0 && o != UNKNOWN && o != reported {
+		if o != h.overridden {
+			h.conn.logger().Info("Leaving instance status overridden by others", "app", h.instance.App,
+				"instance", h.instance.Id(), "status", o)
+			h.overridden = o
+		}
+		return
+	}
+	h.overridden = ""
+	if err := h.conn.UpdateInstanceStatusContext(ctx, &h.instance, status); err != nil {
+		// Try again after the next round.
+		h.report(err)
+		return
+	}
+	h.conn.logger().Info("Reported instance status", "app", h.instance.App, "instance", h.instance.Id(), "status", status)
+	h.m.Lock()
+	h.reported = status
+	h.m.Unlock()
+}
+
+func (h *HealthReporter) report(err error) {
+	// Drop attempted sends when the consumer hasn't received the last buffered failure.
+	select {
+	case h.errs <- err:
+	default:
+	}
+}
+
+// Status returns the status most recently reported to Eureka, or the instance's original status if
+// none has been reported yet.
+func (h *HealthReporter) Status() StatusType {
+	h.m.RLock()
+	defer h.m.RUnlock()
+	return h.reported
+}
+
+// Errors returns a channel on which the HealthReporter reports failures to update the instance's
+// status with Eureka. If the consumer has yet to receive a previously reported failure, subsequent
+// failures are dropped until it does. The channel is closed once the HealthReporter stops.
+func (h *HealthReporter) Errors() <-chan error {
+	return h.errs
+}
+
+// Stop ceases running the checks, leaving the instance's status with Eureka as last reported.
+//
+// It is safe to call Stop more than once.
+func (h *HealthReporter) Stop() {
+	if h == nil {
+		return
+	}
+	h.stopOnce.Do(func() {
+		close(h.done)
+	})
+	<-h.finished
+}
+
+// pagePath returns the path of the given page URL, or the fallback path if the URL is empty or
+// invalid.
+func pagePath(pageURL, fallback string) string {
+	if u, err := url.Parse(pageURL); err == nil && len(u.Path) > 0 {
+		return u.Path
+	}
+	return fallback
+}
+
+// Handler returns an http.Handler that serves the instance's health check and status pages at the
+// paths of its HealthCheckUrl and StatusPageUrl fields, or at "/healthcheck" and "/status" should
+// those fields be empty.
+//
+// The health check page responds with status code 200 while the reported status is UP, and 503
+// otherwise. The status page describes the instance and the results of the most recent round of
+// checks. Both are rendered as JSON.
+func (h *HealthReporter) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc(pagePath(h.instance.HealthCheckUrl, "/healthcheck"), h.serveHealthCheck)
+	mux.HandleFunc(pagePath(h.instance.StatusPageUrl, "/status"), h.serveStatusPage)
+	return mux
+}
+
+func writeHealthJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(v)
+}
+
+func (h *HealthReporter) serveHealthCheck(w http.ResponseWriter, r *http.Request) {
+	status := h.Status()
+	code := http.StatusOK
+	if status != UP {
+		code = http.StatusServiceUnavailable
+	}
+	writeHealthJSON(w, code, map[string]StatusType{"status": status})
+}
+
+type healthCheckResult struct {
+	Name  string `json:"name"`
+	Error string `json:"error,omitempty"`
+}
+
+func (h *HealthReporter) serveStatusPage(w http.ResponseWriter, r *http.Request) {
+	h.m.RLock()
+	status := h.reported
+	checks := make([]healthCheckResult, 0, len(h.results))
+	for name, err := range h.results {
+		result := healthCheckResult{Name: name}
+		if err != nil {
+			result.Error = err.Error()
+		}
+		checks = append(checks, result)
+	}
+	h.m.RUnlock()
+	sort.Slice(checks, func(i, j int) bool { return checks[i].Name < checks[j].Name })
+	writeHealthJSON(w, http.StatusOK, struct {
+		App      string              `json:"app"`
+		Instance string              `json:"instance"`
+		Status   StatusType          `json:"status"`
+		Checks   []healthCheckResult `json:"checks"`
+	}{h.instance.App, h.instance.Id(), status, checks})
+}
@@ -0,0 +1,183 @@
+package fargo_test
+
+// MIT Licensed (see README.md) - Copyright (c) 2013 Hudl <@Hudl>
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hudl/fargo"
+	"github.com/hudl/fargo/fargotest"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// switchableCheck is a health check whose result can be changed while it's in use.
+type switchableCheck struct {
+	m   sync.Mutex
+	err error
+}
+
+func (c *switchableCheck) set(err error) {
+	c.m.Lock()
+	defer c.m.Unlock()
+	c.err = err
+}
+
+func (c *switchableCheck) check(context.Context) error {
+	c.m.Lock()
+	defer c.m.Unlock()
+	return c.err
+}
+
+func TestHealthReporter(t *testing.T) {
+	Convey("Given a registered instance that is starting", t, func() {
+		s := fargotest.NewServer()
+		defer s.Close()
+		e := fargo.NewConn(s.URL)
+		e.Logger = fargo.NoopLogger{}
+		ins := &fargo.Instance{
+			InstanceId:     "i-1",
+			HostName:       "i-1.example.com",
+			App:            "TESTAPP",
+			IPAddr:         "10.0.0.1",
+			VipAddress:     "testapp",
+			Status:         fargo.STARTING,
+			HealthCheckUrl: "http://i-1.example.com:8080/health",
+			DataCenterInfo: fargo.DataCenterInfo{Name: fargo.MyOwn},
+		}
+		So(e.RegisterInstance(ins), ShouldBeNil)
+		registeredStatus := func() fargo.StatusType {
+			instances := s.Instances("TESTAPP")
+			if len(instances) != 1 {
+				return ""
+			}
+			return instances[0].Status
+		}
+		awaitStatus := func(status fargo.StatusType) fargo.StatusType {
+			for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(time.Millisecond) {
+				if registeredStatus() == status {
+					break
+				}
+			}
+			return registeredStatus()
+		}
+
+		Convey("a health reporter reports the status its checks yield", func() {
+			db := &switchableCheck{}
+			h, err := e.NewHealthReporter(context.Background(), ins, map[string]fargo.HealthCheck{
+				"db":    db.check,
+				"cache": func(context.Context) error { return nil },
+			}, fargo.HealthCheckInterval(5*time.Millisecond), fargo.HealthCheckThreshold(2))
+			So(err, ShouldBeNil)
+			defer h.Stop()
+			So(awaitStatus(fargo.UP), ShouldEqual, fargo.UP)
+			So(ins.Status, ShouldEqual, fargo.STARTING)
+
+			Convey("as they fail", func() {
+				db.set(errors.New("connection refused"))
+				So(awaitStatus(fargo.DOWN), ShouldEqual, fargo.DOWN)
+				So(h.Status(), ShouldEqual, fargo.DOWN)
+
+				Convey("and recover", func() {
+					db.set(nil)
+					So(awaitStatus(fargo.UP), ShouldEqual, fargo.UP)
+				})
+			})
+
+			Convey("as they take the instance out of service", func() {
+				db.set(fmt.Errorf("draining: %w", fargo.ErrOutOfService))
+				So(awaitStatus(fargo.OUTOFSERVICE), ShouldEqual, fargo.OUTOFSERVICE)
+			})
+
+			Convey("but leaves alone a status override set by others", func() {
+				operator := *ins
+				So(e.SetStatusOverride(&operator, fargo.OUTOFSERVICE), ShouldBeNil)
+				time.Sleep(50 * time.Millisecond)
+				So(registeredStatus(), ShouldEqual, fargo.OUTOFSERVICE)
+				db.set(errors.New("connection refused"))
+				time.Sleep(50 * time.Millisecond)
+				So(registeredStatus(), ShouldEqual, fargo.OUTOFSERVICE)
+
+				Convey("until it's removed", func() {
+					So(e.RemoveStatusOverride(&operator, ""), ShouldBeNil)
+					So(awaitStatus(fargo.DOWN), ShouldEqual, fargo.DOWN)
+					db.set(nil)
+					So(awaitStatus(fargo.UP), ShouldEqual, fargo.UP)
+				})
+			})
+
+			Convey("and serves its health check and status pages", func() {
+				for h.Status() != fargo.UP {
+					time.Sleep(time.Millisecond)
+				}
+				handler := h.Handler()
+				rec := httptest.NewRecorder()
+				handler.ServeHTTP(rec, httptest.NewRequest("GET", "/health", nil))
+				So(rec.Code, ShouldEqual, http.StatusOK)
+				So(rec.Body.String(), ShouldContainSubstring, `"UP"`)
+
+				db.set(errors.New("connection refused"))
+				So(awaitStatus(fargo.DOWN), ShouldEqual, fargo.DOWN)
+				rec = httptest.NewRecorder()
+				handler.ServeHTTP(rec, httptest.NewRequest("GET", "/health", nil))
+				So(rec.Code, ShouldEqual, http.StatusServiceUnavailable)
+
+				rec = httptest.NewRecorder()
+				handler.ServeHTTP(rec, httptest.NewRequest("GET", "/status", nil))
+				So(rec.Code, ShouldEqual, http.StatusOK)
+				So(rec.Body.String(), ShouldContainSubstring, `"error":"connection refused"`)
+			})
+		})
+
+		Convey("a single failure doesn't flap the instance's status", func() {
+			var m sync.Mutex
+			rounds, updates := 0, 0
+			s.SetFailureHook(func(r *http.Request) int {
+				if r.Method == "PUT" && strings.HasSuffix(r.URL.Path, "/status") {
+					m.Lock()
+					updates++
+					m.Unlock()
+				}
+				return 0
+			})
+			h, err := e.NewHealthReporter(context.Background(), ins, map[string]fargo.HealthCheck{
+				"flaky": func(context.Context) error {
+					m.Lock()
+					defer m.Unlock()
+					rounds++
+					if rounds == 3 {
+						return errors.New("timed out")
+					}
+					return nil
+				},
+			}, fargo.HealthCheckInterval(time.Millisecond), fargo.HealthCheckThreshold(2))
+			So(err, ShouldBeNil)
+			for {
+				m.Lock()
+				done := rounds > 5
+				m.Unlock()
+				if done {
+					break
+				}
+				time.Sleep(time.Millisecond)
+			}
+			h.Stop()
+			So(registeredStatus(), ShouldEqual, fargo.UP)
+			m.Lock()
+			defer m.Unlock()
+			So(updates, ShouldEqual, 1)
+		})
+
+		Convey("invalid options are rejected", func() {
+			_, err := e.NewHealthReporter(context.Background(), ins, nil, fargo.HealthCheckThreshold(0))
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
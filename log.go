@@ -3,14 +3,124 @@ package fargo
 // MIT Licensed (see README.md) - Copyright (c) 2013 Hudl <@Hudl>
 
 import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
 	"github.com/op/go-logging"
 )
 
-var log = logging.MustGetLogger("fargo")
-var metadataLog = logging.MustGetLogger("fargo.metadata")
-var marshalLog = logging.MustGetLogger("fargo.marshal")
+// A Logger records fargo's diagnostic messages. Each message is accompanied by a sequence of
+// alternating keys and values describing it, such as "app", "TESTAPP", "status", 404. The keys
+// fargo uses include "app", "instance", "vip", "url", "path", "status", and "error".
+//
+// A *slog.Logger satisfies this interface.
+type Logger interface {
+	Debug(msg string, keyvals ...interface{})
+	Info(msg string, keyvals ...interface{})
+	Warn(msg string, keyvals ...interface{})
+	Error(msg string, keyvals ...interface{})
+}
+
+// NoopLogger is a Logger that discards every message.
+type NoopLogger struct{}
+
+func (NoopLogger) Debug(msg string, keyvals ...interface{}) {}
+func (NoopLogger) Info(msg string, keyvals ...interface{})  {}
+func (NoopLogger) Warn(msg string, keyvals ...interface{})  {}
+func (NoopLogger) Error(msg string, keyvals ...interface{}) {}
+
+// goLogger adapts a go-logging logger to the Logger interface, appending each key-value pair to
+// the message in the form key=value.
+type goLogger struct {
+	l *logging.Logger
+}
+
+func (g goLogger) Debug(msg string, keyvals ...interface{}) {
+	g.l.Debugf("%s", formatKeyvals(msg, keyvals))
+}
+
+func (g goLogger) Info(msg string, keyvals ...interface{}) {
+	g.l.Infof("%s", formatKeyvals(msg, keyvals))
+}
+
+func (g goLogger) Warn(msg string, keyvals ...interface{}) {
+	g.l.Warningf("%s", formatKeyvals(msg, keyvals))
+}
+
+func (g goLogger) Error(msg string, keyvals ...interface{}) {
+	g.l.Errorf("%s", formatKeyvals(msg, keyvals))
+}
+
+func formatKeyvals(msg string, keyvals []interface{}) string {
+	if len(keyvals) == 0 {
+		return msg
+	}
+	var b bytes.Buffer
+	b.WriteString(msg)
+	for i := 0; i < len(keyvals); i += 2 {
+		var v interface{} = "(MISSING)"
+		if i+1 < len(keyvals) {
+			v = keyvals[i+1]
+		}
+		s := fmt.Sprint(v)
+		if strings.ContainsAny(s, " =\"\n") || len(s) == 0 {
+			s = strconv.Quote(s)
+		}
+		fmt.Fprintf(&b, " %v=%s", keyvals[i], s)
+	}
+	return b.String()
+}
+
+// loggers holds the Loggers used for messages not logged on behalf of a particular connection.
+type loggers struct {
+	main     Logger
+	metadata Logger
+	marshal  Logger
+}
+
+var packageLoggers atomic.Value
 
 func init() {
 	logging.SetLevel(logging.WARNING, "fargo.metadata")
 	logging.SetLevel(logging.WARNING, "fargo.marshal")
+	packageLoggers.Store(loggers{
+		main:     goLogger{logging.MustGetLogger("fargo")},
+		metadata: goLogger{logging.MustGetLogger("fargo.metadata")},
+		marshal:  goLogger{logging.MustGetLogger("fargo.marshal")},
+	})
+}
+
+// SetLogger directs all of fargo's messages to the given Logger, other than those logged on behalf
+// of connections that have their own Logger. If l is nil, fargo discards those messages.
+//
+// By default, fargo logs via github.com/op/go-logging, using the loggers named "fargo",
+// "fargo.metadata", and "fargo.marshal".
+func SetLogger(l Logger) {
+	if l == nil {
+		l = NoopLogger{}
+	}
+	packageLoggers.Store(loggers{l, l, l})
+}
+
+func log() Logger {
+	return packageLoggers.Load().(loggers).main
+}
+
+func metadataLog() Logger {
+	return packageLoggers.Load().(loggers).metadata
+}
+
+func marshalLog() Logger {
+	return packageLoggers.Load().(loggers).marshal
+}
+
+// logger returns the Logger to use for messages logged on behalf of this connection.
+func (e *EurekaConnection) logger() Logger {
+	if e.Logger != nil {
+		return e.Logger
+	}
+	return log()
 }
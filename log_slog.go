@@ -0,0 +1,21 @@
+//go:build go1.21
+// +build go1.21
+
+package fargo
+
+// MIT Licensed (see README.md) - Copyright (c) 2013 Hudl <@Hudl>
+
+import (
+	"log/slog"
+)
+
+var _ Logger = (*slog.Logger)(nil)
+
+// SlogLogger returns a Logger that records messages via the given slog logger, or via the default
+// slog logger if l is nil. Since a *slog.Logger is itself a Logger, this is only a convenience.
+func SlogLogger(l *slog.Logger) Logger {
+	if l == nil {
+		return slog.Default()
+	}
+	return l
+}
@@ -0,0 +1,28 @@
+//go:build go1.21
+// +build go1.21
+
+package fargo
+
+// MIT Licensed (see README.md) - Copyright (c) 2013 Hudl <@Hudl>
+
+import (
+	"bytes"
+	"log/slog"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestSlogLogger(t *testing.T) {
+	Convey("A slog logger records fargo's messages with structured fields", t, func() {
+		var buf bytes.Buffer
+		e := NewConn("http://127.0.0.1:1")
+		e.Logger = SlogLogger(slog.New(slog.NewJSONHandler(&buf, nil)))
+		e.logger().Warn("Unexpected response", "app", "TESTAPP", "status", 503)
+		So(buf.String(), ShouldContainSubstring, `"msg":"Unexpected response","app":"TESTAPP","status":503`)
+	})
+
+	Convey("Without a slog logger, the default slog logger is used", t, func() {
+		So(SlogLogger(nil), ShouldEqual, slog.Default())
+	})
+}
@@ -0,0 +1,84 @@
+package fargo
+
+// MIT Licensed (see README.md) - Copyright (c) 2013 Hudl <@Hudl>
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// recordingLogger records each message it receives as a line of the form "LEVEL msg k=v ...".
+type recordingLogger struct {
+	m     sync.Mutex
+	lines []string
+}
+
+func (l *recordingLogger) record(level, msg string, keyvals []interface{}) {
+	l.m.Lock()
+	defer l.m.Unlock()
+	l.lines = append(l.lines, level+" "+formatKeyvals(msg, keyvals))
+}
+
+func (l *recordingLogger) Debug(msg string, keyvals ...interface{}) { l.record("DEBUG", msg, keyvals) }
+func (l *recordingLogger) Info(msg string, keyvals ...interface{})  { l.record("INFO", msg, keyvals) }
+func (l *recordingLogger) Warn(msg string, keyvals ...interface{})  { l.record("WARN", msg, keyvals) }
+func (l *recordingLogger) Error(msg string, keyvals ...interface{}) { l.record("ERROR", msg, keyvals) }
+
+func TestLogger(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer server.Close()
+
+	Convey("Given a connection with its own Logger", t, func() {
+		own := &recordingLogger{}
+		e := NewConn(server.URL)
+		e.HTTPClient = &http.Client{Transport: http.DefaultTransport}
+		e.Logger = own
+
+		Convey("and a package-level Logger", func() {
+			saved := packageLoggers.Load()
+			defer packageLoggers.Store(saved)
+			pkg := &recordingLogger{}
+			SetLogger(pkg)
+
+			Convey("messages about the connection's requests go to the connection's Logger", func() {
+				_, err := e.GetApp("TESTAPP")
+				So(err, ShouldNotBeNil)
+				So(own.lines, ShouldContain, "ERROR App not found app=TESTAPP status=404")
+				So(pkg.lines, ShouldBeEmpty)
+			})
+
+			Convey("messages not tied to a connection go to the package-level Logger", func() {
+				_, err := ReadConfig("/nonexistent/fargo.gcfg")
+				So(err, ShouldNotBeNil)
+				So(pkg.lines, ShouldHaveLength, 1)
+				So(pkg.lines[0], ShouldStartWith, "ERROR Unable to read config file file=/nonexistent/fargo.gcfg error=")
+				So(own.lines, ShouldBeEmpty)
+			})
+
+			Convey("connections without their own Logger use the package-level Logger", func() {
+				e.Logger = nil
+				_, err := e.GetApp("TESTAPP")
+				So(err, ShouldNotBeNil)
+				So(pkg.lines, ShouldContain, "ERROR App not found app=TESTAPP status=404")
+			})
+		})
+	})
+
+	Convey("Key-value pairs are formatted after the message", t, func() {
+		So(formatKeyvals("msg", nil), ShouldEqual, "msg")
+		So(formatKeyvals("msg", []interface{}{"app", "TESTAPP", "status", 200}), ShouldEqual, "msg app=TESTAPP status=200")
+		So(formatKeyvals("msg", []interface{}{"error", "no such host", "body", ""}), ShouldEqual, `msg error="no such host" body=""`)
+		So(formatKeyvals("msg", []interface{}{"dangling"}), ShouldEqual, "msg dangling=(MISSING)")
+	})
+
+	Convey("A NoopLogger discards messages", t, func() {
+		var l Logger = NoopLogger{}
+		l.Error("discarded", "key", "value")
+	})
+}
@@ -28,7 +28,7 @@ func intFromJSONNumberOrString(jv interface{}, description string) (int, error)
 // UnmarshalJSON is a custom JSON unmarshaler for GetAppsResponse to deal with
 // sometimes non-wrapped Application arrays when there is only a single Application item.
 func (r *GetAppsResponse) UnmarshalJSON(b []byte) error {
-	marshalLog.Debugf("GetAppsResponse.UnmarshalJSON b:%s\n", string(b))
+	marshalLog().Debug("GetAppsResponse.UnmarshalJSON", "b", string(b))
 	resolveDelta := func(d interface{}) (int, error) {
 		return intFromJSONNumberOrString(d, "versions delta")
 	}
@@ -43,7 +43,7 @@ func (r *GetAppsResponse) UnmarshalJSON(b []byte) error {
 	}
 	var err error
 	if err = json.Unmarshal(b, &auxArray); err == nil {
-		marshalLog.Debugf("GetAppsResponse.UnmarshalJSON array:%+v\n", auxArray)
+		marshalLog().Debug("GetAppsResponse.UnmarshalJSON", "array", fmt.Sprintf("%+v", auxArray))
 		r.VersionsDelta, err = resolveDelta(auxArray.VersionsDelta)
 		return err
 	}
@@ -57,7 +57,7 @@ func (r *GetAppsResponse) UnmarshalJSON(b []byte) error {
 	if err := json.Unmarshal(b, &auxSingle); err != nil {
 		return err
 	}
-	marshalLog.Debugf("GetAppsResponse.UnmarshalJSON single:%+v\n", auxSingle)
+	marshalLog().Debug("GetAppsResponse.UnmarshalJSON", "single", fmt.Sprintf("%+v", auxSingle))
 	if r.VersionsDelta, err = resolveDelta(auxSingle.VersionsDelta); err != nil {
 		return err
 	}
@@ -77,13 +77,13 @@ type applicationSingle struct {
 // UnmarshalJSON is a custom JSON unmarshaler for Application to deal with
 // sometimes non-wrapped Instance array when there is only a single Instance item.
 func (a *Application) UnmarshalJSON(b []byte) error {
-	marshalLog.Debugf("Application.UnmarshalJSON b:%s\n", string(b))
+	marshalLog().Debug("Application.UnmarshalJSON", "b", string(b))
 	var err error
 
 	// Normal array case
 	var aa applicationArray
 	if err = json.Unmarshal(b, &aa); err == nil {
-		marshalLog.Debugf("Application.UnmarshalJSON aa:%+v\n", aa)
+		marshalLog().Debug("Application.UnmarshalJSON", "aa", fmt.Sprintf("%+v", aa))
 		*a = Application(aa)
 		return nil
 	}
@@ -91,7 +91,7 @@ func (a *Application) UnmarshalJSON(b []byte) error {
 	// Bogus non-wrapped case
 	var as applicationSingle
 	if err = json.Unmarshal(b, &as); err == nil {
-		marshalLog.Debugf("Application.UnmarshalJSON as:%+v\n", as)
+		marshalLog().Debug("Application.UnmarshalJSON", "as", fmt.Sprintf("%+v", as))
 		a.Name = as.Name
 		a.Instances = make([]*Instance, 1, 1)
 		a.Instances[0] = as.Instance
@@ -264,7 +264,7 @@ func (i InstanceMetadata) MarshalXML(e *xml.Encoder, start xml.StartElement) err
 	if i.parsed != nil {
 		for key, value := range i.parsed {
 			t := startLocalName(key)
-			tokens = append(tokens, t, xml.CharData(value.(string)), xml.EndElement{Name: t.Name})
+			tokens = append(tokens, t, xml.CharData(itemString(value)), xml.EndElement{Name: t.Name})
 		}
 	}
 	tokens = append(tokens, xml.EndElement{Name: start.Name})
@@ -456,7 +456,8 @@ func (i *DataCenterInfo) UnmarshalJSON(b []byte) error {
 		*preliminaryDataCenterInfo
 		PreliminaryMetadata map[string]interface{} `json:"metadata"`
 	}{
-		PreliminaryMetadata: make(map[string]interface{}, 11),
+		preliminaryDataCenterInfo: &preliminaryDataCenterInfo{},
+		PreliminaryMetadata:       make(map[string]interface{}, 11),
 	}
 	if err := json.Unmarshal(b, &aux); err != nil {
 		return err
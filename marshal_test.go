@@ -0,0 +1,47 @@
+package fargo
+
+// MIT Licensed (see README.md) - Copyright (c) 2013 Hudl <@Hudl>
+
+import (
+	"encoding/json"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestDataCenterInfoUnmarshalJSON(t *testing.T) {
+	Convey("Data center info read from JSON", t, func() {
+		Convey("fills in Amazon metadata, even where Eureka rendered values as numbers", func() {
+			var d DataCenterInfo
+			err := json.Unmarshal([]byte(`{
+				"@class": "com.netflix.appinfo.AmazonInfo",
+				"name": "Amazon",
+				"metadata": {"instance-id": "i-123", "ami-launch-index": 0, "local-ipv4": "10.0.0.1", "availability-zone": "us-east-1a"}
+			}`), &d)
+			So(err, ShouldBeNil)
+			So(d.Name, ShouldEqual, Amazon)
+			So(d.Class, ShouldEqual, "com.netflix.appinfo.AmazonInfo")
+			So(d.Metadata, ShouldResemble, AmazonMetadataType{
+				InstanceID:       "i-123",
+				AmiLaunchIndex:   "0",
+				LocalIpv4:        "10.0.0.1",
+				AvailabilityZone: "us-east-1a",
+			})
+			So(d.AlternateMetadata, ShouldBeNil)
+		})
+
+		Convey("fills in the alternate metadata of other data centers", func() {
+			var d DataCenterInfo
+			err := json.Unmarshal([]byte(`{"@class": "com.netflix.appinfo.MyDataCenterInfo", "name": "MyOwn", "metadata": {"rack": 7}}`), &d)
+			So(err, ShouldBeNil)
+			So(d.Name, ShouldEqual, MyOwn)
+			So(d.AlternateMetadata, ShouldResemble, map[string]string{"rack": "7"})
+		})
+
+		Convey("tolerates a missing metadata object", func() {
+			var d DataCenterInfo
+			So(json.Unmarshal([]byte(`{"name": "MyOwn"}`), &d), ShouldBeNil)
+			So(d.Name, ShouldEqual, MyOwn)
+		})
+	})
+}
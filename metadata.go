@@ -3,8 +3,13 @@ package fargo
 // MIT Licensed (see README.md) - Copyright (c) 2013 Hudl <@Hudl>
 
 import (
+	"bytes"
 	"encoding/json"
+	"encoding/xml"
+	"errors"
 	"fmt"
+	"sort"
+	"unicode"
 
 	"github.com/clbanning/x2j"
 )
@@ -14,20 +19,128 @@ func (a *Application) ParseAllMetadata() error {
 	for _, instance := range a.Instances {
 		err := instance.Metadata.parse()
 		if err != nil {
-			log.Errorf("Failed parsing metadata for Instance=%s of Application=%s: %s",
-				instance.HostName, a.Name, err.Error())
+			log().Error("Failed parsing metadata", "app", a.Name, "instance", instance.Id(), "error", err)
 			return err
 		}
 	}
 	return nil
 }
 
-// SetMetadataString for a given instance before register
+// SetMetadataString for a given instance before register. It leaves the metadata unchanged, and
+// logs an error, should the key not be a valid XML element name.
 func (ins *Instance) SetMetadataString(key, value string) {
-	if ins.Metadata.parsed == nil {
-		ins.Metadata.parsed = map[string]interface{}{}
+	changed, err := ins.Metadata.changed(map[string]string{key: value}, nil)
+	if err != nil {
+		log().Error("Failed setting metadata", "app", ins.App, "instance", ins.Id(), "error", err)
+		return
+	}
+	ins.Metadata = changed
+}
+
+// changed returns a copy of the metadata with the given items set and the items with the given
+// keys removed, with its Raw form regenerated to match, as JSON if it was JSON before, and as XML
+// otherwise. The remaining items keep the text they held in Raw. It returns a *MetadataError
+// should a key to be set not be a valid XML element name, as Eureka serves metadata as XML too.
+func (im *InstanceMetadata) changed(set map[string]string, remove []string) (InstanceMetadata, error) {
+	for k := range set {
+		if !isXMLName(k) {
+			return *im, &MetadataError{Key: k, Err: errors.New("not a valid XML element name")}
+		}
+	}
+	items, err := im.verbatim()
+	if err != nil {
+		// Start afresh rather than carry forward what can't be read.
+		items = make(map[string]interface{}, len(set))
+	}
+	for k, v := range set {
+		items[k] = v
+	}
+	for _, k := range remove {
+		delete(items, k)
+	}
+	isJSON := len(im.Raw) > 0 && im.Raw[0] == '{'
+	raw, err := renderMetadata(items, isJSON)
+	if err != nil {
+		return *im, err
+	}
+	return InstanceMetadata{
+		Raw:    raw,
+		parsed: items,
+	}, nil
+}
+
+// verbatim parses Raw without converting the items' values to numbers or booleans, such that
+// each item keeps the text it was registered with: items parsed from XML are strings, and numbers
+// parsed from JSON are json.Numbers.
+func (im *InstanceMetadata) verbatim() (map[string]interface{}, error) {
+	items := make(map[string]interface{})
+	if len(im.Raw) == 0 {
+		return items, nil
+	}
+	if im.Raw[0] == '{' {
+		d := json.NewDecoder(bytes.NewReader(im.Raw))
+		d.UseNumber()
+		if err := d.Decode(&items); err != nil {
+			return nil, fmt.Errorf("error unmarshalling: %s", err.Error())
+		}
+		return items, nil
+	}
+	fullDoc := append(append([]byte("<d>"), im.Raw...), []byte("</d>")...)
+	parsedDoc, err := x2j.ByteDocToMap(fullDoc, false)
+	if err != nil {
+		return nil, fmt.Errorf("error unmarshalling: %s", err.Error())
+	}
+	if m, ok := parsedDoc["d"].(map[string]interface{}); ok {
+		items = m
+	}
+	return items, nil
+}
+
+// isXMLName reports whether s may name an XML element.
+func isXMLName(s string) bool {
+	if len(s) == 0 {
+		return false
+	}
+	for i, r := range s {
+		switch {
+		case unicode.IsLetter(r) || r == '_':
+		case i > 0 && (unicode.IsDigit(r) || r == '-' || r == '.' || unicode.Is(unicode.Mn, r)):
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// itemString renders a parsed metadata item as the string Eureka would hold.
+func itemString(item interface{}) string {
+	if s, err := metadataString(item); err == nil {
+		return s
+	}
+	return fmt.Sprint(item)
+}
+
+// renderMetadata renders the given items in the form that InstanceMetadata's Raw field holds:
+// either as a JSON object or as a sequence of XML elements, in order by key.
+func renderMetadata(items map[string]interface{}, asJSON bool) ([]byte, error) {
+	if asJSON {
+		return json.Marshal(items)
+	}
+	keys := make([]string, 0, len(items))
+	for k := range items {
+		if !isXMLName(k) {
+			return nil, &MetadataError{Key: k, Err: errors.New("not a valid XML element name")}
+		}
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var buf bytes.Buffer
+	for _, k := range keys {
+		fmt.Fprintf(&buf, "<%s>", k)
+		xml.EscapeText(&buf, []byte(itemString(items[k])))
+		fmt.Fprintf(&buf, "</%s>", k)
 	}
-	ins.Metadata.parsed[key] = value
+	return buf.Bytes(), nil
 }
 
 func (im *InstanceMetadata) parse() error {
@@ -35,13 +148,13 @@ func (im *InstanceMetadata) parse() error {
 		im.parsed = make(map[string]interface{})
 		return nil
 	}
-	metadataLog.Debugf("InstanceMetadata.parse: %s", im.Raw)
+	metadataLog().Debug("InstanceMetadata.parse", "raw", string(im.Raw))
 
 	if len(im.Raw) > 0 && im.Raw[0] == '{' {
 		// JSON
 		err := json.Unmarshal(im.Raw, &im.parsed)
 		if err != nil {
-			log.Errorf("Error unmarshalling: %s", err.Error())
+			log().Error("Error unmarshalling", "error", err)
 			return fmt.Errorf("error unmarshalling: %s", err.Error())
 		}
 	} else {
@@ -49,7 +162,7 @@ func (im *InstanceMetadata) parse() error {
 		fullDoc := append(append([]byte("<d>"), im.Raw...), []byte("</d>")...)
 		parsedDoc, err := x2j.ByteDocToMap(fullDoc, true)
 		if err != nil {
-			log.Errorf("Error unmarshalling: %s", err.Error())
+			log().Error("Error unmarshalling", "error", err)
 			return fmt.Errorf("error unmarshalling: %s", err.Error())
 		}
 		im.parsed = parsedDoc["d"].(map[string]interface{})
@@ -0,0 +1,342 @@
+package fargo
+
+// MIT Licensed (see README.md) - Copyright (c) 2013 Hudl <@Hudl>
+
+import (
+	"encoding"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+type metadataField struct {
+	key       string
+	omitEmpty bool
+	index     int
+}
+
+func metadataFields(t reflect.Type) []metadataField {
+	var fields []metadataField
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if len(f.PkgPath) > 0 {
+			// Unexported
+			continue
+		}
+		tag := f.Tag.Get("eureka")
+		if tag == "-" {
+			continue
+		}
+		name, opts := tag, ""
+		if i := strings.IndexByte(tag, ','); i >= 0 {
+			name, opts = tag[:i], tag[i+1:]
+		}
+		if len(name) == 0 {
+			name = f.Name
+		}
+		fields = append(fields, metadataField{
+			key:       name,
+			omitEmpty: opts == "omitempty",
+			index:     i,
+		})
+	}
+	return fields
+}
+
+func isStruct(t reflect.Type) bool {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	return t.Kind() == reflect.Struct && !reflect.PtrTo(t).Implements(reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem())
+}
+
+// Decode stores the instance's metadata items in the fields of the struct to which v points, per
+// the fields' "eureka" tags. Fields whose items are absent are left unchanged. It returns a
+// *MetadataError naming the first item that can't be stored in its field.
+//
+// Decode and SetMetadata map the exported fields of a struct to metadata items, keyed by the
+// name given in each field's "eureka" tag, or by the field's own name lacking such a tag. A tag of
+// "-" excludes the field, and an ",omitempty" suffix omits the field from SetMetadata when it
+// holds its zero value. For example:
+//
+//	type serviceMetadata struct {
+//		Version  string        `eureka:"version"`
+//		Weight   float64       `eureka:"weight,omitempty"`
+//		Timeout  time.Duration `eureka:"timeout"`
+//		Features []string      `eureka:"features"`
+//		Build    struct {
+//			SHA string `eureka:"sha"`
+//		} `eureka:"build"`
+//	}
+//
+// Eureka holds each metadata item as a string, so SetMetadata renders values as follows: numbers
+// and booleans per the strconv package, durations per time.Duration's String method, values
+// implementing encoding.TextMarshaler per that method, and slices as their elements' renderings
+// joined by commas. Fields of nested structs become items whose keys are prefixed by the nested
+// struct's key and a period, such as "build.sha" above. Decode accepts the same forms, as well as
+// the numbers, booleans, arrays, and nested objects present in metadata that was registered as JSON.
+// String fields receive each item's text as registered, such that "1.10" remains "1.10".
+func (im *InstanceMetadata) Decode(v interface{}) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() || rv.Elem().Kind() != reflect.Struct {
+		return errors.New("metadata can only be decoded into a non-nil pointer to a struct")
+	}
+	// Decode from the items' text as registered, lest "2.0" reach a string field as "2".
+	items, err := im.verbatim()
+	if err != nil {
+		return err
+	}
+	return decodeMetadataStruct(items, "", rv.Elem())
+}
+
+// nestedMetadata returns the items nested under the given key, whether held as an object or as
+// items whose keys carry the given key as a prefix.
+func nestedMetadata(items map[string]interface{}, key string) map[string]interface{} {
+	if m, ok := items[key].(map[string]interface{}); ok {
+		return m
+	}
+	var nested map[string]interface{}
+	prefix := key + "."
+	for k, v := range items {
+		if strings.HasPrefix(k, prefix) {
+			if nested == nil {
+				nested = make(map[string]interface{})
+			}
+			nested[k[len(prefix):]] = v
+		}
+	}
+	return nested
+}
+
+func decodeMetadataStruct(items map[string]interface{}, prefix string, sv reflect.Value) error {
+	for _, f := range metadataFields(sv.Type()) {
+		fv := sv.Field(f.index)
+		key := prefix + f.key
+		if isStruct(fv.Type()) {
+			nested := nestedMetadata(items, f.key)
+			if nested == nil {
+				continue
+			}
+			for fv.Kind() == reflect.Ptr {
+				if fv.IsNil() {
+					fv.Set(reflect.New(fv.Type().Elem()))
+				}
+				fv = fv.Elem()
+			}
+			if err := decodeMetadataStruct(nested, key+".", fv); err != nil {
+				return err
+			}
+			continue
+		}
+		item, ok := items[f.key]
+		if !ok {
+			continue
+		}
+		if err := decodeMetadataValue(item, fv); err != nil {
+			return &MetadataError{Key: key, Err: err}
+		}
+	}
+	return nil
+}
+
+// metadataString renders a scalar item parsed from JSON or XML as the string Eureka would hold.
+func metadataString(item interface{}) (string, error) {
+	switch v := item.(type) {
+	case string:
+		return v, nil
+	case json.Number:
+		return v.String(), nil
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	case nil:
+		return "", nil
+	default:
+		return "", fmt.Errorf("cannot decode %T as a scalar value", item)
+	}
+}
+
+func decodeMetadataValue(item interface{}, fv reflect.Value) error {
+	if fv.Kind() == reflect.Ptr {
+		if fv.IsNil() {
+			fv.Set(reflect.New(fv.Type().Elem()))
+		}
+		return decodeMetadataValue(item, fv.Elem())
+	}
+	if fv.Kind() == reflect.Slice && fv.Type().Elem().Kind() != reflect.Uint8 {
+		var elems []interface{}
+		switch v := item.(type) {
+		case []interface{}:
+			elems = v
+		default:
+			s, err := metadataString(item)
+			if err != nil {
+				return err
+			}
+			if len(s) > 0 {
+				for _, e := range strings.Split(s, ",") {
+					elems = append(elems, strings.TrimSpace(e))
+				}
+			}
+		}
+		slice := reflect.MakeSlice(fv.Type(), len(elems), len(elems))
+		for i, e := range elems {
+			if err := decodeMetadataValue(e, slice.Index(i)); err != nil {
+				return err
+			}
+		}
+		fv.Set(slice)
+		return nil
+	}
+	s, err := metadataString(item)
+	if err != nil {
+		return err
+	}
+	if u, ok := fv.Addr().Interface().(encoding.TextUnmarshaler); ok {
+		return u.UnmarshalText([]byte(s))
+	}
+	if fv.Type() == durationType {
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return err
+		}
+		fv.SetInt(int64(d))
+		return nil
+	}
+	switch fv.Kind() {
+	case reflect.String:
+		fv.SetString(s)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return err
+		}
+		fv.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(s, 10, fv.Type().Bits())
+		if err != nil {
+			return err
+		}
+		fv.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, err := strconv.ParseUint(s, 10, fv.Type().Bits())
+		if err != nil {
+			return err
+		}
+		fv.SetUint(n)
+	case reflect.Float32, reflect.Float64:
+		f, err := strconv.ParseFloat(s, fv.Type().Bits())
+		if err != nil {
+			return err
+		}
+		fv.SetFloat(f)
+	default:
+		return fmt.Errorf("unsupported field type %s", fv.Type())
+	}
+	return nil
+}
+
+// SetMetadata sets the instance's metadata items from the fields of the given struct, or pointer
+// to a struct, per the fields' "eureka" tags as described for InstanceMetadata.Decode, leaving any
+// other items in place. It returns a *MetadataError naming the first field that can't be rendered
+// as an item, in which case it leaves the instance's metadata unchanged.
+func (ins *Instance) SetMetadata(v interface{}) error {
+	rv := reflect.ValueOf(v)
+	for rv.Kind() == reflect.Ptr && !rv.IsNil() {
+		rv = rv.Elem()
+	}
+	if rv.Kind() != reflect.Struct {
+		return errors.New("metadata can only be set from a struct or a non-nil pointer to a struct")
+	}
+	items := make(map[string]string)
+	if err := encodeMetadataStruct(rv, "", items); err != nil {
+		return err
+	}
+	changed, err := ins.Metadata.changed(items, nil)
+	if err != nil {
+		return err
+	}
+	ins.Metadata = changed
+	return nil
+}
+
+func encodeMetadataStruct(sv reflect.Value, prefix string, items map[string]string) error {
+	for _, f := range metadataFields(sv.Type()) {
+		fv := sv.Field(f.index)
+		key := prefix + f.key
+		if f.omitEmpty && fv.IsZero() {
+			continue
+		}
+		if isStruct(fv.Type()) {
+			for fv.Kind() == reflect.Ptr && !fv.IsNil() {
+				fv = fv.Elem()
+			}
+			if fv.Kind() == reflect.Ptr {
+				continue
+			}
+			if err := encodeMetadataStruct(fv, key+".", items); err != nil {
+				return err
+			}
+			continue
+		}
+		if fv.Kind() == reflect.Ptr && fv.IsNil() {
+			continue
+		}
+		s, err := encodeMetadataValue(fv)
+		if err != nil {
+			return &MetadataError{Key: key, Err: err}
+		}
+		items[key] = s
+	}
+	return nil
+}
+
+func encodeMetadataValue(fv reflect.Value) (string, error) {
+	for fv.Kind() == reflect.Ptr {
+		if fv.IsNil() {
+			return "", nil
+		}
+		fv = fv.Elem()
+	}
+	if m, ok := fv.Interface().(encoding.TextMarshaler); ok {
+		b, err := m.MarshalText()
+		return string(b), err
+	}
+	if fv.Type() == durationType {
+		return time.Duration(fv.Int()).String(), nil
+	}
+	switch fv.Kind() {
+	case reflect.String:
+		return fv.String(), nil
+	case reflect.Bool:
+		return strconv.FormatBool(fv.Bool()), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(fv.Int(), 10), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return strconv.FormatUint(fv.Uint(), 10), nil
+	case reflect.Float32, reflect.Float64:
+		return strconv.FormatFloat(fv.Float(), 'f', -1, fv.Type().Bits()), nil
+	case reflect.Slice, reflect.Array:
+		elems := make([]string, fv.Len())
+		for i := range elems {
+			s, err := encodeMetadataValue(fv.Index(i))
+			if err != nil {
+				return "", err
+			}
+			if strings.Contains(s, ",") {
+				return "", fmt.Errorf("slice element %q contains a comma", s)
+			}
+			elems[i] = s
+		}
+		return strings.Join(elems, ","), nil
+	default:
+		return "", fmt.Errorf("unsupported field type %s", fv.Type())
+	}
+}
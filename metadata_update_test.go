@@ -0,0 +1,88 @@
+package fargo_test
+
+// MIT Licensed (see README.md) - Copyright (c) 2013 Hudl <@Hudl>
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/hudl/fargo"
+	"github.com/hudl/fargo/fargotest"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestMetadataUpdates(t *testing.T) {
+	for _, useJson := range []bool{false, true} {
+		Convey("Given an instance registered with metadata", t, func() {
+			s := fargotest.NewServer()
+			defer s.Close()
+			e := fargo.NewConn(s.URL)
+			e.UseJson = useJson
+			e.Logger = fargo.NoopLogger{}
+			ins := &fargo.Instance{
+				InstanceId:     "i-1",
+				HostName:       "i-1.example.com",
+				App:            "TESTAPP",
+				IPAddr:         "10.0.0.1",
+				VipAddress:     "testapp",
+				Status:         fargo.UP,
+				DataCenterInfo: fargo.DataCenterInfo{Name: fargo.MyOwn},
+			}
+			ins.SetMetadataString("color", "red")
+			ins.SetMetadataString("shape", "square")
+			So(e.RegisterInstance(ins), ShouldBeNil)
+
+			registered := func() map[string]interface{} {
+				instances := s.Instances("TESTAPP")
+				So(instances, ShouldHaveLength, 1)
+				return instances[0].Metadata.GetMap()
+			}
+			// local returns the instance's metadata as reparsed from its raw form.
+			local := func() map[string]interface{} {
+				reparsed := fargo.InstanceMetadata{Raw: ins.Metadata.Raw}
+				var m struct {
+					Color string `eureka:"color"`
+					Shape string `eureka:"shape"`
+					Size  string `eureka:"size"`
+				}
+				So(reparsed.Decode(&m), ShouldBeNil)
+				items := map[string]interface{}{}
+				for k, v := range map[string]string{"color": m.Color, "shape": m.Shape, "size": m.Size} {
+					if len(v) > 0 {
+						items[k] = v
+					}
+				}
+				return items
+			}
+
+			Convey("UpdateMetadata sets several items at once", func() {
+				So(e.UpdateMetadata(ins, map[string]string{"shape": "circle", "size": "large"}), ShouldBeNil)
+				expected := map[string]interface{}{"color": "red", "shape": "circle", "size": "large"}
+				So(registered(), ShouldResemble, expected)
+				So(ins.Metadata.GetMap(), ShouldResemble, expected)
+				So(local(), ShouldResemble, expected)
+
+				Convey("and RemoveMetadata removes them", func() {
+					So(e.RemoveMetadata(ins, "color", "size"), ShouldBeNil)
+					expected := map[string]interface{}{"shape": "circle"}
+					So(registered(), ShouldResemble, expected)
+					So(local(), ShouldResemble, expected)
+				})
+			})
+
+			Convey("RemoveMetadata leaves the instance alone should Eureka reject it", func() {
+				s.SetFailureHook(func(r *http.Request) int {
+					if r.Method == "POST" {
+						return http.StatusInternalServerError
+					}
+					return 0
+				})
+				e.Retries = -1
+				So(e.RemoveMetadata(ins, "color"), ShouldNotBeNil)
+				expected := map[string]interface{}{"color": "red", "shape": "square"}
+				So(registered(), ShouldResemble, expected)
+				So(local(), ShouldResemble, expected)
+			})
+		})
+	}
+}
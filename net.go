@@ -3,19 +3,22 @@ package fargo
 // MIT Licensed (see README.md) - Copyright (c) 2013 Hudl <@Hudl>
 
 import (
+	"context"
 	"encoding/json"
 	"encoding/xml"
 	"errors"
 	"fmt"
 	"math/rand"
 	"net/http"
+	"net/url"
 	"strings"
 	"sync"
 	"time"
 )
 
-func (e *EurekaConnection) generateURL(slugs ...string) string {
-	return strings.Join(append([]string{e.SelectServiceURL()}, slugs...), "/")
+// urlPath joins the given slugs into a path relative to a Eureka service URL.
+func urlPath(slugs ...string) string {
+	return strings.Join(slugs, "/")
 }
 
 func (e *EurekaConnection) marshal(v interface{}) ([]byte, error) {
@@ -24,7 +27,7 @@ func (e *EurekaConnection) marshal(v interface{}) ([]byte, error) {
 		if err != nil {
 			// marshal the JSON *with* indents so it's readable in the error message
 			out, _ := json.MarshalIndent(v, "", "    ")
-			log.Errorf("Error marshalling JSON value=%v. Error:\"%s\" JSON body=\"%s\"", v, err.Error(), string(out))
+			e.logger().Error("Error marshalling JSON", "value", v, "body", string(out), "error", err)
 			return nil, err
 		}
 		return out, nil
@@ -33,7 +36,7 @@ func (e *EurekaConnection) marshal(v interface{}) ([]byte, error) {
 		if err != nil {
 			// marshal the XML *with* indents so it's readable in the error message
 			out, _ := xml.MarshalIndent(v, "", "    ")
-			log.Errorf("Error marshalling XML value=%v. Error:\"%s\" JSON body=\"%s\"", v, err.Error(), string(out))
+			e.logger().Error("Error marshalling XML", "value", v, "body", string(out), "error", err)
 			return nil, err
 		}
 		return out, nil
@@ -42,20 +45,26 @@ func (e *EurekaConnection) marshal(v interface{}) ([]byte, error) {
 
 // GetApp returns a single eureka application by name
 func (e *EurekaConnection) GetApp(name string) (*Application, error) {
+	return e.GetAppContext(context.Background(), name)
+}
+
+// GetAppContext is like GetApp, but honors cancellation and deadlines conveyed by the supplied
+// context.
+func (e *EurekaConnection) GetAppContext(ctx context.Context, name string) (*Application, error) {
 	slug := fmt.Sprintf("%s/%s", EurekaURLSlugs["Apps"], name)
-	reqURL := e.generateURL(slug)
-	log.Debugf("Getting app %s from url %s", name, reqURL)
-	out, rcode, err := getBody(reqURL, e.UseJson)
+	path := urlPath(slug)
+	e.logger().Debug("Getting app", "app", name, "path", path)
+	out, rcode, err := e.getBody(ctx, path, e.UseJson)
 	if err != nil {
-		log.Errorf("Couldn't get app %s, error: %s", name, err.Error())
+		e.logger().Error("Couldn't get app", "app", name, "error", err)
 		return nil, err
 	}
 	if rcode == 404 {
-		log.Errorf("App %s not found (received 404)", name)
+		e.logger().Error("App not found", "app", name, "status", rcode)
 		return nil, AppNotFoundError{specific: name}
 	}
 	if rcode > 299 || rcode < 200 {
-		log.Warningf("Non-200 rcode of %d", rcode)
+		e.logger().Warn("Unexpected response getting app", "app", name, "status", rcode)
 	}
 
 	var v *Application
@@ -67,7 +76,7 @@ func (e *EurekaConnection) GetApp(name string) (*Application, error) {
 		err = xml.Unmarshal(out, &v)
 	}
 	if err != nil {
-		log.Errorf("Unmarshalling error: %s", err.Error())
+		e.logger().Error("Unmarshalling error", "app", name, "error", err)
 		return nil, err
 	}
 
@@ -85,20 +94,44 @@ func (e *EurekaConnection) readAppInto(app *Application) error {
 
 // GetApps returns a map of all Applications
 func (e *EurekaConnection) GetApps() (map[string]*Application, error) {
+	return e.GetAppsContext(context.Background())
+}
+
+// GetAppsContext is like GetApps, but honors cancellation and deadlines conveyed by the supplied
+// context.
+func (e *EurekaConnection) GetAppsContext(ctx context.Context) (map[string]*Application, error) {
 	slug := EurekaURLSlugs["Apps"]
-	reqURL := e.generateURL(slug)
-	log.Debugf("Getting all apps from url %s", reqURL)
-	body, rcode, err := getBody(reqURL, e.UseJson)
+	path := urlPath(slug)
+	e.logger().Debug("Getting all apps", "path", path)
+	body, rcode, err := e.getBody(ctx, path, e.UseJson)
 	if err != nil {
-		log.Errorf("Couldn't get apps, error: %s", err.Error())
+		e.logger().Error("Couldn't get apps", "error", err)
 		return nil, err
 	}
 	if rcode > 299 || rcode < 200 {
-		log.Warningf("Non-200 rcode of %d", rcode)
+		e.logger().Warn("Unexpected response getting apps", "status", rcode)
+	}
+
+	r, err := unmarshalAppsResponse(body, e.UseJson)
+	if err != nil {
+		return nil, err
 	}
 
+	apps := map[string]*Application{}
+	for i, a := range r.Applications {
+		apps[a.Name] = r.Applications[i]
+	}
+	for name, app := range apps {
+		e.logger().Debug("Parsing metadata", "app", name)
+		app.ParseAllMetadata()
+	}
+	return apps, nil
+}
+
+func unmarshalAppsResponse(body []byte, isJson bool) (*GetAppsResponse, error) {
 	var r *GetAppsResponse
-	if e.UseJson {
+	var err error
+	if isJson {
 		var rj GetAppsResponseJson
 		err = json.Unmarshal(body, &rj)
 		r = rj.Response
@@ -106,19 +139,48 @@ func (e *EurekaConnection) GetApps() (map[string]*Application, error) {
 		err = xml.Unmarshal(body, &r)
 	}
 	if err != nil {
-		log.Errorf("Unmarshalling error: %s", err.Error())
+		log().Error("Unmarshalling error", "error", err)
 		return nil, err
 	}
+	if r == nil {
+		r = &GetAppsResponse{}
+	}
+	return r, nil
+}
 
-	apps := map[string]*Application{}
-	for i, a := range r.Applications {
-		apps[a.Name] = r.Applications[i]
+// GetAppsDelta returns the changes made to the set of registered applications and instances
+// within Eureka's recent delta window, with each instance's ActionType field indicating whether it
+// was added, modified, or deleted. The AppsHashcode field of the response summarizes the state of
+// the full registry after these changes, allowing a client to check whether applying them to its
+// local copy brought that copy up to date.
+//
+// Eureka servers may decline to serve deltas, in which case this method returns an error bearing
+// the HTTP status code of the response.
+func (e *EurekaConnection) GetAppsDelta() (*GetAppsResponse, error) {
+	return e.GetAppsDeltaContext(context.Background())
+}
+
+// GetAppsDeltaContext is like GetAppsDelta, but honors cancellation and deadlines conveyed by the
+// supplied context.
+func (e *EurekaConnection) GetAppsDeltaContext(ctx context.Context) (*GetAppsResponse, error) {
+	path := urlPath(EurekaURLSlugs["Apps"], "delta")
+	e.logger().Debug("Getting apps delta", "path", path)
+	body, rcode, err := e.getBody(ctx, path, e.UseJson)
+	if err != nil {
+		e.logger().Error("Couldn't get apps delta", "error", err)
+		return nil, err
 	}
-	for name, app := range apps {
-		log.Debugf("Parsing metadata for app %s", name)
+	if rcode != http.StatusOK {
+		return nil, &unsuccessfulHTTPResponse{rcode, "unable to retrieve apps delta"}
+	}
+	r, err := unmarshalAppsResponse(body, e.UseJson)
+	if err != nil {
+		return nil, err
+	}
+	for _, app := range r.Applications {
 		app.ParseAllMetadata()
 	}
-	return apps, nil
+	return r, nil
 }
 
 func instanceCount(apps []*Application) int {
@@ -315,32 +377,24 @@ func filterInstancesInApps(apps []*Application, pred func(*Instance) bool) []*In
 	}
 }
 
-func (e *EurekaConnection) getInstancesByVIPAddress(addr string, secure bool, opts instanceQueryOptions) ([]*Instance, error) {
+func (e *EurekaConnection) getInstancesByVIPAddress(ctx context.Context, addr string, secure bool, opts instanceQueryOptions) ([]*Instance, error) {
 	var slug string
 	if secure {
 		slug = EurekaURLSlugs["InstancesBySecureVIPAddress"]
 	} else {
 		slug = EurekaURLSlugs["InstancesByVIPAddress"]
 	}
-	reqURL := e.generateURL(slug, addr)
-	log.Debugf("Getting instances for VIP address %q from URL %s", addr, reqURL)
-	body, rcode, err := getBody(reqURL, e.UseJson)
+	path := urlPath(slug, addr)
+	e.logger().Debug("Getting instances for VIP address", "vip", addr, "path", path)
+	body, rcode, err := e.getBody(ctx, path, e.UseJson)
 	if err != nil {
 		return nil, err
 	}
 	if rcode != http.StatusOK {
 		return nil, &unsuccessfulHTTPResponse{rcode, "unable to retrieve instances by VIP address"}
 	}
-	var r *GetAppsResponse
-	if e.UseJson {
-		var rj GetAppsResponseJson
-		err = json.Unmarshal(body, &rj)
-		r = rj.Response
-	} else {
-		err = xml.Unmarshal(body, &r)
-	}
+	r, err := unmarshalAppsResponse(body, e.UseJson)
 	if err != nil {
-		log.Errorf("Unmarshalling error: %s", err.Error())
 		return nil, err
 	}
 	var instances []*Instance
@@ -386,11 +440,17 @@ func collectInstanceQueryOptions(opts []InstanceQueryOption) (instanceQueryOptio
 //
 // NB: The VIP address is case-sensitive, and must match the address used at registration time.
 func (e *EurekaConnection) GetInstancesByVIPAddress(addr string, secure bool, opts ...InstanceQueryOption) ([]*Instance, error) {
+	return e.GetInstancesByVIPAddressContext(context.Background(), addr, secure, opts...)
+}
+
+// GetInstancesByVIPAddressContext is like GetInstancesByVIPAddress, but honors cancellation and
+// deadlines conveyed by the supplied context.
+func (e *EurekaConnection) GetInstancesByVIPAddressContext(ctx context.Context, addr string, secure bool, opts ...InstanceQueryOption) ([]*Instance, error) {
 	options, err := collectInstanceQueryOptions(opts)
 	if err != nil {
 		return nil, err
 	}
-	return e.getInstancesByVIPAddress(addr, secure, options)
+	return e.getInstancesByVIPAddress(ctx, addr, secure, options)
 }
 
 // InstanceSetUpdate is the outcome of an attempt to get a fresh snapshot of a Eureka VIP address's
@@ -437,7 +497,7 @@ func scheduleInstanceUpdates(d time.Duration, produce func() ([]*Instance, error
 
 func (e *EurekaConnection) scheduleVIPAddressUpdates(addr string, secure bool, await bool, done <-chan struct{}, opts instanceQueryOptions) <-chan InstanceSetUpdate {
 	produce := func() ([]*Instance, error) {
-		return e.getInstancesByVIPAddress(addr, secure, opts)
+		return e.getInstancesByVIPAddress(context.Background(), addr, secure, opts)
 	}
 	return scheduleInstanceUpdates(e.PollInterval, produce, await, done)
 }
@@ -511,6 +571,7 @@ type InstanceSetSource struct {
 	m         sync.RWMutex
 	instances []*Instance
 	done      chan<- struct{}
+	notifier  instanceChangeNotifier
 }
 
 func (e *EurekaConnection) newInstanceSetSourceFor(produce func() ([]*Instance, error), await bool) *InstanceSetSource {
@@ -523,27 +584,27 @@ func (e *EurekaConnection) newInstanceSetSourceFor(produce func() ([]*Instance,
 	// satisfied the filtering predicate, then it's possible that the slice returned by
 	// getInstancesByVIPAddress (or similar) will be nil. Make it possible to discern when we've
 	// received at least one update in Latest by never storing a nil value for a successful update.
-	if await {
-		if instances, err := produce(); err == nil {
-			if instances != nil {
-				s.instances = instances
-			} else {
-				s.instances = []*Instance{}
+	consume := func(instances []*Instance, err error) {
+		store := func(latest []*Instance) func() {
+			return func() {
+				s.m.Lock()
+				s.instances = latest
+				s.m.Unlock()
 			}
 		}
+		if err != nil {
+			store(nil)()
+			return
+		}
+		if instances == nil {
+			instances = []*Instance{}
+		}
+		s.notifier.publish(instances, store(instances))
 	}
-	consume := func(instances []*Instance, err error) {
-		var latest []*Instance
-		if err == nil {
-			if instances != nil {
-				latest = instances
-			} else {
-				latest = []*Instance{}
-			}
+	if await {
+		if instances, err := produce(); err == nil {
+			consume(instances, nil)
 		}
-		s.m.Lock()
-		s.instances = latest
-		s.m.Unlock()
 	}
 	go exchangeInstancesEvery(e.PollInterval, produce, consume, done)
 	return s
@@ -551,7 +612,7 @@ func (e *EurekaConnection) newInstanceSetSourceFor(produce func() ([]*Instance,
 
 func (e *EurekaConnection) newInstanceSetSourceForVIPAddress(addr string, secure bool, await bool, opts instanceQueryOptions) *InstanceSetSource {
 	produce := func() ([]*Instance, error) {
-		return e.getInstancesByVIPAddress(addr, secure, opts)
+		return e.getInstancesByVIPAddress(context.Background(), addr, secure, opts)
 	}
 	return e.newInstanceSetSourceFor(produce, await)
 }
@@ -630,29 +691,40 @@ func (s *InstanceSetSource) Stop() {
 // but DOES NOT automatically send heartbeats. See HeartBeatInstance for that
 // functionality
 func (e *EurekaConnection) RegisterInstance(ins *Instance) error {
+	return e.RegisterInstanceContext(context.Background(), ins)
+}
+
+// RegisterInstanceContext is like RegisterInstance, but honors cancellation and deadlines conveyed
+// by the supplied context.
+func (e *EurekaConnection) RegisterInstanceContext(ctx context.Context, ins *Instance) error {
 	slug := fmt.Sprintf("%s/%s", EurekaURLSlugs["Apps"], ins.App)
-	reqURL := e.generateURL(slug)
-	log.Debugf("Registering instance with url %s", reqURL)
-	_, rcode, err := getBody(reqURL+"/"+ins.Id(), e.UseJson)
+	path := urlPath(slug)
+	e.logger().Debug("Registering instance", "app", ins.App, "instance", ins.Id(), "path", path)
+	_, rcode, err := e.getBody(ctx, path+"/"+ins.Id(), e.UseJson)
 	if err != nil {
-		log.Errorf("Failed check if Instance=%s exists in app=%s, error: %s",
-			ins.Id(), ins.App, err.Error())
+		e.logger().Error("Failed to check whether instance exists", "app", ins.App, "instance", ins.Id(), "error", err)
 		return err
 	}
 	if rcode == http.StatusOK {
-		log.Noticef("Instance=%s already exists in App=%s, aborting registration", ins.Id(), ins.App)
+		e.logger().Info("Instance already exists, aborting registration", "app", ins.App, "instance", ins.Id())
 		return nil
 	}
-	log.Noticef("Instance=%s not yet registered with App=%s, registering.", ins.Id(), ins.App)
-	return e.ReregisterInstance(ins)
+	e.logger().Info("Instance not yet registered, registering", "app", ins.App, "instance", ins.Id())
+	return e.ReregisterInstanceContext(ctx, ins)
 }
 
 // ReregisterInstance will register the given Instance with eureka but DOES
 // NOT automatically send heartbeats. See HeartBeatInstance for that
 // functionality
 func (e *EurekaConnection) ReregisterInstance(ins *Instance) error {
+	return e.ReregisterInstanceContext(context.Background(), ins)
+}
+
+// ReregisterInstanceContext is like ReregisterInstance, but honors cancellation and deadlines
+// conveyed by the supplied context.
+func (e *EurekaConnection) ReregisterInstanceContext(ctx context.Context, ins *Instance) error {
 	slug := fmt.Sprintf("%s/%s", EurekaURLSlugs["Apps"], ins.App)
-	reqURL := e.generateURL(slug)
+	path := urlPath(slug)
 
 	var out []byte
 	var err error
@@ -665,29 +737,35 @@ func (e *EurekaConnection) ReregisterInstance(ins *Instance) error {
 		return err
 	}
 
-	body, rcode, err := postBody(reqURL, out, e.UseJson)
+	body, rcode, err := e.postBody(ctx, path, out, e.UseJson)
 	if err != nil {
-		log.Errorf("Could not complete registration, error: %s", err.Error())
+		e.logger().Error("Could not complete registration", "app", ins.App, "instance", ins.Id(), "error", err)
 		return err
 	}
 	if rcode != 204 {
-		log.Warningf("HTTP returned %d registering Instance=%s App=%s Body=\"%s\"", rcode,
-			ins.Id(), ins.App, string(body))
+		e.logger().Warn("Unexpected response registering instance", "app", ins.App, "instance", ins.Id(),
+			"status", rcode, "body", string(body))
 		return &unsuccessfulHTTPResponse{rcode, "possible failure registering instance"}
 	}
 
 	// read back our registration to pick up eureka-supplied values
-	e.readInstanceInto(ins)
+	e.readInstanceInto(ctx, ins)
 
 	return nil
 }
 
 // GetInstance gets an Instance from eureka given its app and instanceid.
 func (e *EurekaConnection) GetInstance(app, insId string) (*Instance, error) {
+	return e.GetInstanceContext(context.Background(), app, insId)
+}
+
+// GetInstanceContext is like GetInstance, but honors cancellation and deadlines conveyed by the
+// supplied context.
+func (e *EurekaConnection) GetInstanceContext(ctx context.Context, app, insId string) (*Instance, error) {
 	slug := fmt.Sprintf("%s/%s/%s", EurekaURLSlugs["Apps"], app, insId)
-	reqURL := e.generateURL(slug)
-	log.Debugf("Getting instance with url %s", reqURL)
-	body, rcode, err := getBody(reqURL, e.UseJson)
+	path := urlPath(slug)
+	e.logger().Debug("Getting instance", "app", app, "instance", insId, "path", path)
+	body, rcode, err := e.getBody(ctx, path, e.UseJson)
 	if err != nil {
 		return nil, err
 	}
@@ -705,8 +783,8 @@ func (e *EurekaConnection) GetInstance(app, insId string) (*Instance, error) {
 	return ins, err
 }
 
-func (e *EurekaConnection) readInstanceInto(ins *Instance) error {
-	tins, err := e.GetInstance(ins.App, ins.Id())
+func (e *EurekaConnection) readInstanceInto(ctx context.Context, ins *Instance) error {
+	tins, err := e.GetInstanceContext(ctx, ins.App, ins.Id())
 	if err == nil {
 		tins.UniqueID = ins.UniqueID
 		*ins = *tins
@@ -717,19 +795,25 @@ func (e *EurekaConnection) readInstanceInto(ins *Instance) error {
 // DeregisterInstance will deregister the given Instance from eureka. This is good practice
 // to do before exiting or otherwise going off line.
 func (e *EurekaConnection) DeregisterInstance(ins *Instance) error {
+	return e.DeregisterInstanceContext(context.Background(), ins)
+}
+
+// DeregisterInstanceContext is like DeregisterInstance, but honors cancellation and deadlines
+// conveyed by the supplied context.
+func (e *EurekaConnection) DeregisterInstanceContext(ctx context.Context, ins *Instance) error {
 	slug := fmt.Sprintf("%s/%s/%s", EurekaURLSlugs["Apps"], ins.App, ins.Id())
-	reqURL := e.generateURL(slug)
-	log.Debugf("Deregistering instance with url %s", reqURL)
+	path := urlPath(slug)
+	e.logger().Debug("Deregistering instance", "app", ins.App, "instance", ins.Id(), "path", path)
 
-	rcode, err := deleteReq(reqURL)
+	rcode, err := e.deleteReq(ctx, path)
 	if err != nil {
-		log.Errorf("Could not complete deregistration, error: %s", err.Error())
+		e.logger().Error("Could not complete deregistration", "app", ins.App, "instance", ins.Id(), "error", err)
 		return err
 	}
 	// Eureka promises to return HTTP status code upon deregistration success, but fargo used to accept status code 204
 	// here instead. Accommodate both for backward compatibility with any fake or proxy Eureka stand-ins.
 	if rcode != http.StatusOK && rcode != http.StatusNoContent {
-		log.Warningf("HTTP returned %d deregistering Instance=%s App=%s", rcode, ins.Id(), ins.App)
+		e.logger().Warn("Unexpected response deregistering instance", "app", ins.App, "instance", ins.Id(), "status", rcode)
 		return &unsuccessfulHTTPResponse{rcode, "possible failure deregistering instance"}
 	}
 
@@ -738,65 +822,189 @@ func (e *EurekaConnection) DeregisterInstance(ins *Instance) error {
 
 // AddMetadataString to a given instance. Is immediately sent to Eureka server.
 func (e EurekaConnection) AddMetadataString(ins *Instance, key, value string) error {
-	slug := fmt.Sprintf("%s/%s/%s/metadata", EurekaURLSlugs["Apps"], ins.App, ins.Id())
-	reqURL := e.generateURL(slug)
+	return e.AddMetadataStringContext(context.Background(), ins, key, value)
+}
 
-	params := map[string]string{key: value}
+// AddMetadataStringContext is like AddMetadataString, but honors cancellation and deadlines
+// conveyed by the supplied context.
+func (e EurekaConnection) AddMetadataStringContext(ctx context.Context, ins *Instance, key, value string) error {
+	return e.UpdateMetadataContext(ctx, ins, map[string]string{key: value})
+}
 
-	log.Debugf("Updating instance metadata url=%s metadata=%s", reqURL, params)
-	body, rcode, err := putKV(reqURL, params)
+// UpdateMetadata sets the given metadata items for a given instance in a single request to Eureka,
+// leaving its other items in place. Once Eureka accepts the update, it applies the same items to
+// the instance's local metadata. It returns a *MetadataError, without contacting Eureka, should
+// any key not be a valid XML element name.
+func (e EurekaConnection) UpdateMetadata(ins *Instance, items map[string]string) error {
+	return e.UpdateMetadataContext(context.Background(), ins, items)
+}
+
+// UpdateMetadataContext is like UpdateMetadata, but honors cancellation and deadlines conveyed by
+// the supplied context.
+func (e EurekaConnection) UpdateMetadataContext(ctx context.Context, ins *Instance, items map[string]string) error {
+	if len(items) == 0 {
+		return nil
+	}
+	changed, err := ins.Metadata.changed(items, nil)
 	if err != nil {
-		log.Errorf("Could not complete update, error: %s", err.Error())
+		return err
+	}
+	slug := fmt.Sprintf("%s/%s/%s/metadata", EurekaURLSlugs["Apps"], ins.App, ins.Id())
+	path := urlPath(slug)
+
+	e.logger().Debug("Updating instance metadata", "app", ins.App, "instance", ins.Id(), "path", path, "metadata", items)
+	body, rcode, err := e.putKV(ctx, path, items)
+	if err != nil {
+		e.logger().Error("Could not complete metadata update", "app", ins.App, "instance", ins.Id(), "error", err)
 		return err
 	}
 	if rcode < 200 || rcode >= 300 {
-		log.Warningf("HTTP returned %d updating metadata Instance=%s App=%s Body=\"%s\"", rcode,
-			ins.Id(), ins.App, string(body))
+		e.logger().Warn("Unexpected response updating instance metadata", "app", ins.App, "instance", ins.Id(),
+			"status", rcode, "body", string(body))
 		return &unsuccessfulHTTPResponse{rcode, "possible failure updating instance metadata"}
 	}
-	ins.SetMetadataString(key, value)
+	ins.Metadata = changed
+	return nil
+}
+
+// RemoveMetadata removes the metadata items with the given keys from a given instance. Eureka
+// offers no way to remove individual items, so RemoveMetadata reregisters the instance without
+// them; as with ReregisterInstance, it then reads back the instance from Eureka.
+func (e EurekaConnection) RemoveMetadata(ins *Instance, keys ...string) error {
+	return e.RemoveMetadataContext(context.Background(), ins, keys...)
+}
+
+// RemoveMetadataContext is like RemoveMetadata, but honors cancellation and deadlines conveyed by
+// the supplied context.
+func (e EurekaConnection) RemoveMetadataContext(ctx context.Context, ins *Instance, keys ...string) error {
+	if len(keys) == 0 {
+		return nil
+	}
+	e.logger().Debug("Removing instance metadata", "app", ins.App, "instance", ins.Id(), "keys", keys)
+	// Leave the instance alone should the reregistration fail.
+	updated := *ins
+	changed, err := ins.Metadata.changed(nil, keys)
+	if err != nil {
+		return err
+	}
+	updated.Metadata = changed
+	if err := e.ReregisterInstanceContext(ctx, &updated); err != nil {
+		return err
+	}
+	*ins = updated
 	return nil
 }
 
 // UpdateInstanceStatus updates the status of a given instance with eureka.
 func (e EurekaConnection) UpdateInstanceStatus(ins *Instance, status StatusType) error {
+	return e.UpdateInstanceStatusContext(context.Background(), ins, status)
+}
+
+// UpdateInstanceStatusContext is like UpdateInstanceStatus, but honors cancellation and deadlines
+// conveyed by the supplied context.
+func (e EurekaConnection) UpdateInstanceStatusContext(ctx context.Context, ins *Instance, status StatusType) error {
+	return e.SetStatusOverrideContext(ctx, ins, status)
+}
+
+// SetStatusOverride overrides the status of a given instance with eureka, such that Eureka reports
+// the given status for the instance regardless of the status the instance itself reports, until
+// the override is removed with RemoveStatusOverride. It returns an InstanceNotFoundError if Eureka
+// has no such instance registered.
+func (e EurekaConnection) SetStatusOverride(ins *Instance, status StatusType) error {
+	return e.SetStatusOverrideContext(context.Background(), ins, status)
+}
+
+// SetStatusOverrideContext is like SetStatusOverride, but honors cancellation and deadlines
+// conveyed by the supplied context.
+func (e EurekaConnection) SetStatusOverrideContext(ctx context.Context, ins *Instance, status StatusType) error {
 	slug := fmt.Sprintf("%s/%s/%s/status", EurekaURLSlugs["Apps"], ins.App, ins.Id())
-	reqURL := e.generateURL(slug)
+	path := urlPath(slug)
 
 	params := map[string]string{"value": string(status)}
 
-	log.Debugf("Updating instance status url=%s value=%s", reqURL, status)
-	body, rcode, err := putKV(reqURL, params)
+	e.logger().Debug("Updating instance status", "app", ins.App, "instance", ins.Id(), "path", path, "value", status)
+	body, rcode, err := e.putKV(ctx, path, params)
 	if err != nil {
-		log.Error("Could not complete update, error: ", err.Error())
+		e.logger().Error("Could not complete status update", "app", ins.App, "instance", ins.Id(), "error", err)
 		return err
 	}
+	if rcode == http.StatusNotFound {
+		return InstanceNotFoundError{app: ins.App, id: ins.Id()}
+	}
 	if rcode < 200 || rcode >= 300 {
-		log.Warningf("HTTP returned %d updating status Instance=%s App=%s Body=\"%s\"", rcode,
-			ins.Id(), ins.App, string(body))
+		e.logger().Warn("Unexpected response updating instance status", "app", ins.App, "instance", ins.Id(),
+			"status", rcode, "body", string(body))
 		return &unsuccessfulHTTPResponse{rcode, "possible failure updating instance status"}
 	}
+	ins.Status = status
+	ins.Overriddenstatus = status
+	return nil
+}
+
+// RemoveStatusOverride removes any override of the status of a given instance with eureka, such
+// that Eureka reports the status the instance itself reports. If fallback is nonempty, Eureka
+// reports that status until the instance next reports its own, as with the instance's next
+// heartbeat; otherwise, Eureka retains the overridden status until then. It returns an
+// InstanceNotFoundError if Eureka has no such instance registered.
+func (e EurekaConnection) RemoveStatusOverride(ins *Instance, fallback StatusType) error {
+	return e.RemoveStatusOverrideContext(context.Background(), ins, fallback)
+}
+
+// RemoveStatusOverrideContext is like RemoveStatusOverride, but honors cancellation and deadlines
+// conveyed by the supplied context.
+func (e EurekaConnection) RemoveStatusOverrideContext(ctx context.Context, ins *Instance, fallback StatusType) error {
+	slug := fmt.Sprintf("%s/%s/%s/status", EurekaURLSlugs["Apps"], ins.App, ins.Id())
+	path := urlPath(slug)
+	if len(fallback) > 0 {
+		path += "?" + url.Values{"value": {string(fallback)}}.Encode()
+	}
+
+	e.logger().Debug("Removing instance status override", "app", ins.App, "instance", ins.Id(), "path", path)
+	rcode, err := e.deleteReq(ctx, path)
+	if err != nil {
+		e.logger().Error("Could not complete status override removal", "app", ins.App, "instance", ins.Id(), "error", err)
+		return err
+	}
+	if rcode == http.StatusNotFound {
+		return InstanceNotFoundError{app: ins.App, id: ins.Id()}
+	}
+	if rcode < 200 || rcode >= 300 {
+		e.logger().Warn("Unexpected response removing instance status override", "app", ins.App, "instance", ins.Id(),
+			"status", rcode)
+		return &unsuccessfulHTTPResponse{rcode, "possible failure removing instance status override"}
+	}
+	if len(fallback) > 0 {
+		ins.Status = fallback
+	}
+	ins.Overriddenstatus = UNKNOWN
 	return nil
 }
 
 // HeartBeatInstance sends a single eureka heartbeat. Does not continue sending
 // heartbeats. Errors if the response is not 200.
 func (e *EurekaConnection) HeartBeatInstance(ins *Instance) error {
+	return e.HeartBeatInstanceContext(context.Background(), ins)
+}
+
+// HeartBeatInstanceContext is like HeartBeatInstance, but honors cancellation and deadlines
+// conveyed by the supplied context.
+func (e *EurekaConnection) HeartBeatInstanceContext(ctx context.Context, ins *Instance) error {
 	slug := fmt.Sprintf("%s/%s/%s", EurekaURLSlugs["Apps"], ins.App, ins.Id())
-	reqURL := e.generateURL(slug)
-	log.Debugf("Sending heartbeat with url %s", reqURL)
-	req, err := http.NewRequest("PUT", reqURL, nil)
+	path := urlPath(slug)
+	e.logger().Debug("Sending heartbeat", "app", ins.App, "instance", ins.Id(), "path", path)
+	req, err := http.NewRequest("PUT", path, nil)
 	if err != nil {
-		log.Errorf("Could not create request for heartbeat, error: %s", err.Error())
+		e.logger().Error("Could not create request for heartbeat", "app", ins.App, "instance", ins.Id(), "error", err)
 		return err
 	}
-	_, rcode, err := netReq(req)
+	req = req.WithContext(ctx)
+	_, rcode, err := e.netReq(req)
 	if err != nil {
-		log.Errorf("Error sending heartbeat for Instance=%s App=%s, error: %s", ins.Id(), ins.App, err.Error())
+		e.logger().Error("Error sending heartbeat", "app", ins.App, "instance", ins.Id(), "error", err)
 		return err
 	}
 	if rcode != http.StatusOK {
-		log.Errorf("Sending heartbeat for Instance=%s App=%s returned code %d", ins.Id(), ins.App, rcode)
+		e.logger().Error("Unexpected response sending heartbeat", "app", ins.App, "instance", ins.Id(), "status", rcode)
 		return &unsuccessfulHTTPResponse{rcode, "heartbeat failed"}
 	}
 	return nil
@@ -0,0 +1,129 @@
+package fargo
+
+// MIT Licensed (see README.md) - Copyright (c) 2013 Hudl <@Hudl>
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// defaultRenewalInterval is the period between heartbeats used when an instance's lease
+// information does not specify one, matching the Eureka server's default.
+const defaultRenewalInterval = 30 * time.Second
+
+// A Registrar manages the lifecycle of an instance registered with Eureka: it registers the
+// instance, sends heartbeats to renew its lease, registers the instance again should Eureka
+// forget it, and deregisters the instance when stopped.
+type Registrar struct {
+	conn     *EurekaConnection
+	instance *Instance
+	errs     chan error
+	done     chan struct{}
+	finished chan struct{}
+	stopOnce sync.Once
+	// deregErr is the outcome of the final deregistration attempt, available once finished is
+	// closed.
+	deregErr error
+}
+
+func renewalInterval(ins *Instance) time.Duration {
+	if secs := ins.LeaseInfo.RenewalIntervalInSecs; secs > 0 {
+		return time.Duration(secs) * time.Second
+	}
+	return defaultRenewalInterval
+}
+
+// NewRegistrar registers the given instance with Eureka and returns a Registrar that continues to
+// send heartbeats for it at the interval specified by its LeaseInfo.RenewalIntervalInSecs field,
+// or every 30 seconds if that field is not positive.
+//
+// If a heartbeat fails because Eureka no longer knows about the instance, the Registrar registers
+// it again. The Registrar deregisters the instance once either its Stop method is called or the
+// supplied context is done.
+//
+// It returns an error if the initial registration fails, in which case no heartbeats will be
+// sent.
+func (e *EurekaConnection) NewRegistrar(ctx context.Context, ins *Instance) (*Registrar, error) {
+	if err := e.ReregisterInstanceContext(ctx, ins); err != nil {
+		return nil, err
+	}
+	r := &Registrar{
+		conn:     e,
+		instance: ins,
+		errs:     make(chan error, 1),
+		done:     make(chan struct{}),
+		finished: make(chan struct{}),
+	}
+	go r.run(ctx, renewalInterval(ins))
+	return r, nil
+}
+
+func (r *Registrar) run(ctx context.Context, d time.Duration) {
+	defer close(r.finished)
+	defer close(r.errs)
+	t := time.NewTicker(d)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			// The supplied context is already done, so deregister without it.
+			r.deregErr = r.conn.DeregisterInstance(r.instance)
+			return
+		case <-r.done:
+			r.deregErr = r.conn.DeregisterInstance(r.instance)
+			return
+		case <-t.C:
+			if err := r.beat(ctx); err != nil {
+				r.report(err)
+			}
+		}
+	}
+}
+
+// beat sends a single heartbeat for the managed instance, registering the instance again if
+// Eureka no longer knows about it.
+func (r *Registrar) beat(ctx context.Context) error {
+	err := r.conn.HeartBeatInstanceContext(ctx, r.instance)
+	if err == nil {
+		return nil
+	}
+	if code, ok := HTTPResponseStatusCode(err); ok && code == http.StatusNotFound {
+		r.conn.logger().Info("Instance unknown to Eureka, registering again", "app", r.instance.App, "instance", r.instance.Id())
+		return r.conn.ReregisterInstanceContext(ctx, r.instance)
+	}
+	return err
+}
+
+func (r *Registrar) report(err error) {
+	// Drop attempted sends when the consumer hasn't received the last buffered failure.
+	select {
+	case r.errs <- err:
+	default:
+	}
+}
+
+// Errors returns a channel on which the Registrar reports failures to renew the instance's lease,
+// whether sending a heartbeat or registering the instance again. If the consumer has yet to
+// receive a previously reported failure, subsequent failures are dropped until it does. The
+// channel is closed once the Registrar stops.
+func (r *Registrar) Errors() <-chan error {
+	return r.errs
+}
+
+// Stop ceases sending heartbeats and deregisters the instance from Eureka, returning any error
+// encountered while deregistering it. If the Registrar had already stopped because its context
+// was done, Stop returns the outcome of that earlier deregistration.
+//
+// It is safe to call Stop more than once.
+func (r *Registrar) Stop() error {
+	if r == nil {
+		return nil
+	}
+	r.stopOnce.Do(func() {
+		close(r.done)
+	})
+	<-r.finished
+	return r.deregErr
+}
@@ -0,0 +1,119 @@
+package fargo
+
+// MIT Licensed (see README.md) - Copyright (c) 2013 Hudl <@Hudl>
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// fakeLeaseServer counts the registration lifecycle requests it receives for a single instance,
+// answering heartbeats with 404 whenever the instance is not currently registered.
+type fakeLeaseServer struct {
+	m              sync.Mutex
+	registered     bool
+	registrations  int
+	heartbeats     int
+	deregistration int
+}
+
+func (s *fakeLeaseServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.m.Lock()
+	defer s.m.Unlock()
+	switch {
+	case r.Method == "POST" && r.URL.Path == "/apps/TESTAPP":
+		s.registrations++
+		s.registered = true
+		w.WriteHeader(http.StatusNoContent)
+	case r.Method == "PUT" && r.URL.Path == "/apps/TESTAPP/i-123":
+		s.heartbeats++
+		if !s.registered {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	case r.Method == "DELETE" && r.URL.Path == "/apps/TESTAPP/i-123":
+		s.deregistration++
+		s.registered = false
+		w.WriteHeader(http.StatusOK)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func (s *fakeLeaseServer) forget() {
+	s.m.Lock()
+	defer s.m.Unlock()
+	s.registered = false
+}
+
+func (s *fakeLeaseServer) counts() (registrations, heartbeats, deregistrations int) {
+	s.m.Lock()
+	defer s.m.Unlock()
+	return s.registrations, s.heartbeats, s.deregistration
+}
+
+func TestRegistrar(t *testing.T) {
+	Convey("Given a Eureka server that tracks an instance's lease", t, func() {
+		fake := &fakeLeaseServer{}
+		server := httptest.NewServer(fake)
+		defer server.Close()
+		e := NewConn(server.URL)
+		e.HTTPClient = &http.Client{Transport: http.DefaultTransport}
+		ins := &Instance{
+			InstanceId:     "i-123",
+			App:            "TESTAPP",
+			HostName:       "localhost",
+			DataCenterInfo: DataCenterInfo{Name: MyOwn},
+		}
+
+		Convey("A new Registrar registers the instance", func() {
+			r, err := e.NewRegistrar(context.Background(), ins)
+			So(err, ShouldBeNil)
+			defer r.Stop()
+			registrations, _, _ := fake.counts()
+			So(registrations, ShouldEqual, 1)
+
+			Convey("and renews its lease with a heartbeat", func() {
+				So(r.beat(context.Background()), ShouldBeNil)
+				registrations, heartbeats, _ := fake.counts()
+				So(registrations, ShouldEqual, 1)
+				So(heartbeats, ShouldEqual, 1)
+			})
+
+			Convey("and registers it again when Eureka forgets it", func() {
+				fake.forget()
+				So(r.beat(context.Background()), ShouldBeNil)
+				registrations, heartbeats, _ := fake.counts()
+				So(registrations, ShouldEqual, 2)
+				So(heartbeats, ShouldEqual, 1)
+			})
+
+			Convey("and deregisters it when stopped", func() {
+				So(r.Stop(), ShouldBeNil)
+				So(r.Stop(), ShouldBeNil)
+				_, _, deregistrations := fake.counts()
+				So(deregistrations, ShouldEqual, 1)
+				_, open := <-r.Errors()
+				So(open, ShouldBeFalse)
+			})
+		})
+
+		Convey("A Registrar deregisters the instance when its context is done", func() {
+			ctx, cancel := context.WithCancel(context.Background())
+			r, err := e.NewRegistrar(ctx, ins)
+			So(err, ShouldBeNil)
+			cancel()
+			for range r.Errors() {
+			}
+			_, _, deregistrations := fake.counts()
+			So(deregistrations, ShouldEqual, 1)
+			So(r.Stop(), ShouldBeNil)
+		})
+	})
+}
@@ -0,0 +1,358 @@
+package fargo
+
+// MIT Licensed (see README.md) - Copyright (c) 2013 Hudl <@Hudl>
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrRegistryUnavailable indicates that a RegistrySource has yet to acquire a copy of the Eureka
+// registry against which to answer a query.
+var ErrRegistryUnavailable = errors.New("no copy of the Eureka registry is available")
+
+// A Registry holds a local copy of the full set of applications registered with Eureka.
+//
+// If its connection has EnableDelta set, a Registry refreshes its copy by fetching only the
+// changes made since its previous refresh, falling back to fetching the full set of applications
+// when the changes fail to reconcile with the copy it holds.
+type Registry struct {
+	conn *EurekaConnection
+	// refreshing serializes calls to Refresh.
+	refreshing sync.Mutex
+	m          sync.RWMutex
+	apps       map[string]*Application
+}
+
+// NewRegistry returns a new Registry that refreshes its copy of the Eureka registry through this
+// connection. The Registry is empty until its Refresh method first succeeds.
+func (e *EurekaConnection) NewRegistry() *Registry {
+	return &Registry{conn: e}
+}
+
+// Refresh brings the local copy of the registry up to date, returning any error that precluded
+// doing so. If refreshing fails, the Registry retains its preceding copy.
+func (r *Registry) Refresh() error {
+	return r.RefreshContext(context.Background())
+}
+
+// RefreshContext is like Refresh, but honors cancellation and deadlines conveyed by the supplied
+// context.
+func (r *Registry) RefreshContext(ctx context.Context) error {
+	r.refreshing.Lock()
+	defer r.refreshing.Unlock()
+	r.m.RLock()
+	current := r.apps
+	r.m.RUnlock()
+	if current == nil || !r.conn.EnableDelta {
+		return r.refreshAll(ctx)
+	}
+	delta, err := r.conn.GetAppsDeltaContext(ctx)
+	if err != nil {
+		if ctx.Err() != nil {
+			return err
+		}
+		r.conn.logger().Warn("Failed to fetch registry delta, fetching full registry instead", "error", err)
+		return r.refreshAll(ctx)
+	}
+	apps := applyDelta(current, delta.Applications)
+	if hashcode := reconcileHashCode(apps); hashcode != delta.AppsHashcode {
+		r.conn.logger().Info("Registry delta hash code does not match local hash code, fetching full registry",
+			"delta_hashcode", delta.AppsHashcode, "local_hashcode", hashcode)
+		return r.refreshAll(ctx)
+	}
+	r.m.Lock()
+	r.apps = apps
+	r.m.Unlock()
+	return nil
+}
+
+func (r *Registry) refreshAll(ctx context.Context) error {
+	apps, err := r.conn.GetAppsContext(ctx)
+	if err != nil {
+		return err
+	}
+	r.m.Lock()
+	r.apps = apps
+	r.m.Unlock()
+	return nil
+}
+
+// Apps returns the applications in the most recently refreshed copy of the registry, keyed by
+// name, or nil if no refresh has yet succeeded. Callers must not modify the returned map or the
+// applications within it.
+func (r *Registry) Apps() map[string]*Application {
+	if r == nil {
+		return nil
+	}
+	r.m.RLock()
+	defer r.m.RUnlock()
+	return r.apps
+}
+
+// applyDelta returns a copy of the supplied applications with the instance changes in delta
+// applied. It does not modify the supplied map or any of the applications within it, so that
+// readers of the preceding copy may continue to use it safely.
+func applyDelta(apps map[string]*Application, delta []*Application) map[string]*Application {
+	result := make(map[string]*Application, len(apps))
+	for name, app := range apps {
+		result[name] = app
+	}
+	// copied notes the applications already copied while applying this delta.
+	copied := make(map[string]bool)
+	appFor := func(name string) *Application {
+		app := result[name]
+		if app != nil && copied[name] {
+			return app
+		}
+		fresh := &Application{Name: name}
+		if app != nil {
+			fresh.Instances = make([]*Instance, len(app.Instances), len(app.Instances)+1)
+			copy(fresh.Instances, app.Instances)
+		}
+		result[name] = fresh
+		copied[name] = true
+		return fresh
+	}
+	for _, d := range delta {
+		for _, instance := range d.Instances {
+			switch instance.ActionType {
+			case ADDED, MODIFIED:
+				app := appFor(d.Name)
+				if i := indexOfInstance(app.Instances, instance.Id()); i >= 0 {
+					app.Instances[i] = instance
+				} else {
+					app.Instances = append(app.Instances, instance)
+				}
+			case DELETED:
+				if _, ok := result[d.Name]; !ok {
+					continue
+				}
+				app := appFor(d.Name)
+				if i := indexOfInstance(app.Instances, instance.Id()); i >= 0 {
+					app.Instances = append(app.Instances[:i], app.Instances[i+1:]...)
+				}
+				if len(app.Instances) == 0 {
+					delete(result, d.Name)
+				}
+			}
+		}
+	}
+	return result
+}
+
+func indexOfInstance(instances []*Instance, id string) int {
+	for i, instance := range instances {
+		if instance.Id() == id {
+			return i
+		}
+	}
+	return -1
+}
+
+// reconcileHashCode summarizes the supplied applications in the same manner as the Eureka server
+// does when reporting its "apps__hashcode" value: the count of instances in each status, ordered
+// by status name, as in "DOWN_1_UP_4_".
+func reconcileHashCode(apps map[string]*Application) string {
+	counts := make(map[StatusType]int)
+	for _, app := range apps {
+		for _, instance := range app.Instances {
+			counts[instance.Status]++
+		}
+	}
+	statuses := make([]string, 0, len(counts))
+	for status := range counts {
+		statuses = append(statuses, string(status))
+	}
+	sort.Strings(statuses)
+	var hashcode []byte
+	for _, status := range statuses {
+		hashcode = append(hashcode, status...)
+		hashcode = append(hashcode, '_')
+		hashcode = strconv.AppendInt(hashcode, int64(counts[StatusType(status)]), 10)
+		hashcode = append(hashcode, '_')
+	}
+	return string(hashcode)
+}
+
+func refreshRegistryEvery(d time.Duration, r *Registry, done <-chan struct{}) {
+	t := time.NewTicker(d)
+	defer t.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-t.C:
+			if err := r.Refresh(); err != nil {
+				r.conn.logger().Error("Failure refreshing registry", "error", err)
+			}
+		}
+	}
+}
+
+// A RegistrySource holds a periodically refreshed copy of the full Eureka registry, answering
+// queries for applications and instances from memory rather than by contacting Eureka. A single
+// RegistrySource can stand in for many AppSources and InstanceSetSources.
+//
+// Unlike those sources, a RegistrySource retains its most recent copy of the registry when an
+// attempt to refresh it fails.
+type RegistrySource struct {
+	registry *Registry
+	m        sync.Mutex
+	done     chan<- struct{}
+}
+
+// NewRegistrySource returns a new RegistrySource that offers a periodically refreshed copy of the
+// Eureka registry, using the connection's configured polling interval as its period. If the
+// connection has EnableDelta set, it refreshes its copy incrementally; see Registry for details.
+//
+// If await is true, it waits for the first refresh to complete before returning, though it's
+// possible that that first attempt could fail, so that subsequent queries would fail with
+// ErrRegistryUnavailable until a later refresh succeeds.
+func (e *EurekaConnection) NewRegistrySource(await bool) *RegistrySource {
+	done := make(chan struct{})
+	s := &RegistrySource{
+		registry: e.NewRegistry(),
+		done:     done,
+	}
+	if await {
+		if err := s.registry.Refresh(); err != nil {
+			e.logger().Error("Failure refreshing registry", "error", err)
+		}
+	}
+	go refreshRegistryEvery(e.PollInterval, s.registry, done)
+	return s
+}
+
+// Latest returns the applications in the most recently acquired copy of the registry, keyed by
+// name, or nil if no refresh has yet succeeded. Callers must not modify the returned map or the
+// applications within it.
+func (s *RegistrySource) Latest() map[string]*Application {
+	if s == nil {
+		return nil
+	}
+	return s.registry.Apps()
+}
+
+// GetApp returns the application with the given name from the most recently acquired copy of the
+// registry. Callers must not modify the returned application.
+func (s *RegistrySource) GetApp(name string) (*Application, error) {
+	apps := s.Latest()
+	if apps == nil {
+		return nil, ErrRegistryUnavailable
+	}
+	app, ok := apps[name]
+	if !ok {
+		return nil, AppNotFoundError{specific: name}
+	}
+	return app, nil
+}
+
+// vipAddressIncludes reports whether the given VIP address appears in the supplied comma-separated
+// list of VIP addresses, as registered by an instance.
+func vipAddressIncludes(list, addr string) bool {
+	for len(list) != 0 {
+		var candidate string
+		if i := strings.IndexByte(list, ','); i >= 0 {
+			candidate, list = list[:i], list[i+1:]
+		} else {
+			candidate, list = list, ""
+		}
+		if strings.TrimSpace(candidate) == addr {
+			return true
+		}
+	}
+	return false
+}
+
+// GetInstancesByVIPAddress returns the set of instances registered with the given VIP address in
+// the most recently acquired copy of the registry, selecting either an insecure or secure VIP
+// address with the given name, potentially filtered per the constraints supplied as options.
+//
+// The returned slice is freshly allocated, but callers must not modify the instances within it.
+//
+// NB: The VIP address is case-sensitive, and must match the address used at registration time.
+func (s *RegistrySource) GetInstancesByVIPAddress(addr string, secure bool, opts ...InstanceQueryOption) ([]*Instance, error) {
+	options, err := collectInstanceQueryOptions(opts)
+	if err != nil {
+		return nil, err
+	}
+	apps := s.Latest()
+	if apps == nil {
+		return nil, ErrRegistryUnavailable
+	}
+	var instances []*Instance
+	for _, app := range apps {
+		for _, instance := range app.Instances {
+			vipAddress := instance.VipAddress
+			if secure {
+				vipAddress = instance.SecureVipAddress
+			}
+			if !vipAddressIncludes(vipAddress, addr) {
+				continue
+			}
+			if pred := options.predicate; pred != nil && !pred(instance) {
+				continue
+			}
+			instances = append(instances, instance)
+		}
+	}
+	if intn := options.intn; intn != nil {
+		shuffleInstances(instances, intn)
+	}
+	return instances, nil
+}
+
+// GetAppInstances returns the set of instances from the application with the given name in the
+// most recently acquired copy of the registry, potentially filtered per the constraints supplied
+// as options.
+//
+// The returned slice is freshly allocated, but callers must not modify the instances within it.
+func (s *RegistrySource) GetAppInstances(name string, opts ...InstanceQueryOption) ([]*Instance, error) {
+	options, err := collectInstanceQueryOptions(opts)
+	if err != nil {
+		return nil, err
+	}
+	app, err := s.GetApp(name)
+	if err != nil {
+		return nil, err
+	}
+	var instances []*Instance
+	if pred := options.predicate; pred != nil {
+		for _, instance := range app.Instances {
+			if pred(instance) {
+				instances = append(instances, instance)
+			}
+		}
+	} else {
+		instances = make([]*Instance, len(app.Instances))
+		copy(instances, app.Instances)
+	}
+	if intn := options.intn; intn != nil {
+		shuffleInstances(instances, intn)
+	}
+	return instances, nil
+}
+
+// Stop turns off a RegistrySource, so that it will no longer attempt to refresh its copy of the
+// registry.
+//
+// It is safe to query a stopped source, which continues to answer from its last copy.
+func (s *RegistrySource) Stop() {
+	if s == nil {
+		return
+	}
+	// Allow multiple calls to Stop by precluding repeated attempts to close an already closed
+	// channel.
+	s.m.Lock()
+	defer s.m.Unlock()
+	if s.done != nil {
+		close(s.done)
+		s.done = nil
+	}
+}
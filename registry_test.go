@@ -0,0 +1,216 @@
+package fargo
+
+// MIT Licensed (see README.md) - Copyright (c) 2013 Hudl <@Hudl>
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestReconcileHashCode(t *testing.T) {
+	Convey("An empty registry has an empty hash code", t, func() {
+		So(reconcileHashCode(nil), ShouldEqual, "")
+	})
+	Convey("A registry's hash code counts its instances by status in order", t, func() {
+		apps := map[string]*Application{
+			"A": {Name: "A", Instances: []*Instance{{Status: UP}, {Status: DOWN}, {Status: UP}}},
+			"B": {Name: "B", Instances: []*Instance{{Status: UP}, {Status: OUTOFSERVICE}}},
+		}
+		So(reconcileHashCode(apps), ShouldEqual, "DOWN_1_OUT_OF_SERVICE_1_UP_3_")
+	})
+}
+
+func TestApplyDelta(t *testing.T) {
+	Convey("Given a registry with two applications", t, func() {
+		a1 := &Instance{InstanceId: "a1", Status: UP}
+		a2 := &Instance{InstanceId: "a2", Status: UP}
+		b1 := &Instance{InstanceId: "b1", Status: UP}
+		apps := map[string]*Application{
+			"A": {Name: "A", Instances: []*Instance{a1, a2}},
+			"B": {Name: "B", Instances: []*Instance{b1}},
+		}
+		Convey("adding an instance to a new application creates it", func() {
+			c1 := &Instance{InstanceId: "c1", Status: UP, ActionType: ADDED}
+			result := applyDelta(apps, []*Application{{Name: "C", Instances: []*Instance{c1}}})
+			So(result, ShouldContainKey, "C")
+			So(result["C"].Instances, ShouldResemble, []*Instance{c1})
+			So(apps, ShouldNotContainKey, "C")
+		})
+		Convey("modifying an instance replaces it without disturbing the original", func() {
+			a2Down := &Instance{InstanceId: "a2", Status: DOWN, ActionType: MODIFIED}
+			result := applyDelta(apps, []*Application{{Name: "A", Instances: []*Instance{a2Down}}})
+			So(result["A"].Instances, ShouldResemble, []*Instance{a1, a2Down})
+			So(apps["A"].Instances, ShouldResemble, []*Instance{a1, a2})
+			So(result["B"], ShouldPointTo, apps["B"])
+		})
+		Convey("deleting an application's last instance removes the application", func() {
+			result := applyDelta(apps, []*Application{{Name: "B", Instances: []*Instance{{InstanceId: "b1", ActionType: DELETED}}}})
+			So(result, ShouldNotContainKey, "B")
+			So(apps, ShouldContainKey, "B")
+		})
+		Convey("deleting an unknown instance changes nothing", func() {
+			result := applyDelta(apps, []*Application{{Name: "D", Instances: []*Instance{{InstanceId: "d1", ActionType: DELETED}}}})
+			So(result, ShouldResemble, apps)
+		})
+	})
+}
+
+// fakeDeltaServer serves a fixed full registry from /apps and a fixed delta from /apps/delta,
+// counting the requests for each.
+type fakeDeltaServer struct {
+	m          sync.Mutex
+	full       string
+	delta      string
+	fullCount  int
+	deltaCount int
+}
+
+func (s *fakeDeltaServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.m.Lock()
+	defer s.m.Unlock()
+	switch r.URL.Path {
+	case "/apps":
+		s.fullCount++
+		fmt.Fprint(w, s.full)
+	case "/apps/delta":
+		s.deltaCount++
+		fmt.Fprint(w, s.delta)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+const registryXMLTemplate = `<applications>
+  <versions__delta>1</versions__delta>
+  <apps__hashcode>%s</apps__hashcode>
+  <application>
+    <name>A</name>
+    %s
+  </application>
+</applications>`
+
+func instanceXML(id string, status StatusType, action ActionType) string {
+	return fmt.Sprintf(`<instance><instanceId>%s</instanceId><app>A</app><status>%s</status><actionType>%s</actionType></instance>`,
+		id, status, action)
+}
+
+func TestRegistryRefresh(t *testing.T) {
+	Convey("Given a Eureka server offering registry deltas", t, func() {
+		fake := &fakeDeltaServer{
+			full: fmt.Sprintf(registryXMLTemplate, "UP_1_", instanceXML("a1", UP, "")),
+		}
+		server := httptest.NewServer(fake)
+		defer server.Close()
+		e := NewConn(server.URL)
+		e.HTTPClient = &http.Client{Transport: http.DefaultTransport}
+		e.EnableDelta = true
+		r := e.NewRegistry()
+		So(r.Apps(), ShouldBeNil)
+
+		Convey("the first refresh fetches the full registry", func() {
+			So(r.Refresh(), ShouldBeNil)
+			So(fake.fullCount, ShouldEqual, 1)
+			So(fake.deltaCount, ShouldEqual, 0)
+			So(r.Apps()["A"].Instances, ShouldHaveLength, 1)
+
+			Convey("a later refresh applies a reconcilable delta", func() {
+				fake.delta = fmt.Sprintf(registryXMLTemplate, "DOWN_1_UP_1_", instanceXML("a2", DOWN, ADDED))
+				So(r.Refresh(), ShouldBeNil)
+				So(fake.fullCount, ShouldEqual, 1)
+				So(fake.deltaCount, ShouldEqual, 1)
+				So(r.Apps()["A"].Instances, ShouldHaveLength, 2)
+			})
+
+			Convey("a later refresh falls back to the full registry on a hash code mismatch", func() {
+				fake.delta = fmt.Sprintf(registryXMLTemplate, "UP_2_", instanceXML("a2", DOWN, ADDED))
+				So(r.Refresh(), ShouldBeNil)
+				So(fake.fullCount, ShouldEqual, 2)
+				So(fake.deltaCount, ShouldEqual, 1)
+				So(r.Apps()["A"].Instances, ShouldHaveLength, 1)
+			})
+		})
+
+		Convey("with deltas disabled, every refresh fetches the full registry", func() {
+			e.EnableDelta = false
+			So(r.Refresh(), ShouldBeNil)
+			So(r.Refresh(), ShouldBeNil)
+			So(fake.fullCount, ShouldEqual, 2)
+			So(fake.deltaCount, ShouldEqual, 0)
+		})
+	})
+}
+
+func TestRegistrySource(t *testing.T) {
+	Convey("Given a Eureka server with instances at two VIP addresses", t, func() {
+		instances := `<instance><instanceId>a1</instanceId><app>A</app><status>UP</status><vipAddress>a,shared</vipAddress></instance>
+<instance><instanceId>a2</instanceId><app>A</app><status>DOWN</status><vipAddress>a</vipAddress><secureVipAddress>a</secureVipAddress></instance>`
+		fake := &fakeDeltaServer{
+			full: fmt.Sprintf(registryXMLTemplate, "DOWN_1_UP_1_", instances),
+		}
+		server := httptest.NewServer(fake)
+		defer server.Close()
+		e := NewConn(server.URL)
+		e.HTTPClient = &http.Client{Transport: http.DefaultTransport}
+		e.PollInterval = time.Hour
+
+		Convey("a source that has yet to refresh answers no queries", func() {
+			s := e.NewRegistrySource(false)
+			defer s.Stop()
+			So(s.Latest(), ShouldBeNil)
+			_, err := s.GetApp("A")
+			So(err, ShouldEqual, ErrRegistryUnavailable)
+			_, err = s.GetInstancesByVIPAddress("a", false)
+			So(err, ShouldEqual, ErrRegistryUnavailable)
+		})
+
+		Convey("a source that awaits its first refresh", func() {
+			s := e.NewRegistrySource(true)
+			defer s.Stop()
+			So(fake.fullCount, ShouldEqual, 1)
+
+			Convey("finds known applications", func() {
+				app, err := s.GetApp("A")
+				So(err, ShouldBeNil)
+				So(app.Instances, ShouldHaveLength, 2)
+			})
+			Convey("reports unknown applications", func() {
+				_, err := s.GetApp("B")
+				So(err, ShouldHaveSameTypeAs, AppNotFoundError{})
+			})
+			Convey("finds instances by VIP address", func() {
+				instances, err := s.GetInstancesByVIPAddress("a", false)
+				So(err, ShouldBeNil)
+				So(instances, ShouldHaveLength, 2)
+				instances, err = s.GetInstancesByVIPAddress("shared", false)
+				So(err, ShouldBeNil)
+				So(instances, ShouldHaveLength, 1)
+				So(instances[0].InstanceId, ShouldEqual, "a1")
+				instances, err = s.GetInstancesByVIPAddress("a", true)
+				So(err, ShouldBeNil)
+				So(instances, ShouldHaveLength, 1)
+				So(instances[0].InstanceId, ShouldEqual, "a2")
+			})
+			Convey("filters instances per the supplied options", func() {
+				instances, err := s.GetInstancesByVIPAddress("a", false, ThatAreUp)
+				So(err, ShouldBeNil)
+				So(instances, ShouldHaveLength, 1)
+				So(instances[0].InstanceId, ShouldEqual, "a1")
+				instances, err = s.GetAppInstances("A", WithStatus(DOWN), Shuffled)
+				So(err, ShouldBeNil)
+				So(instances, ShouldHaveLength, 1)
+				So(instances[0].InstanceId, ShouldEqual, "a2")
+			})
+			Convey("retains its copy after stopping", func() {
+				s.Stop()
+				s.Stop()
+				So(s.Latest(), ShouldContainKey, "A")
+			})
+		})
+	})
+}
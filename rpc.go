@@ -4,90 +4,121 @@ package fargo
 
 import (
 	"bytes"
+	"context"
+	"crypto/tls"
 	"io/ioutil"
 	"net"
 	"net/http"
 	"net/url"
+	"strings"
 	"time"
 )
 
+// HttpClient is the HTTP client used by connections that lack their own client in their
+// HTTPClient field.
 var HttpClient = &http.Client{
 	Transport: transport,
 	Timeout:   30 * time.Second,
 }
 
-var transport = &http.Transport{
-	Dial: (&net.Dialer{
-		Timeout: 5 * time.Second,
-	}).Dial,
-	ResponseHeaderTimeout: 10 * time.Second,
+var transport = newTransport(nil)
+
+func newTransport(tlsConfig *tls.Config) *http.Transport {
+	return &http.Transport{
+		Dial: (&net.Dialer{
+			Timeout: 5 * time.Second,
+		}).Dial,
+		ResponseHeaderTimeout: 10 * time.Second,
+		TLSClientConfig:       tlsConfig,
+	}
 }
 
-func postBody(reqURL string, reqBody []byte, isJson bool) ([]byte, int, error) {
-	req, err := http.NewRequest("POST", reqURL, bytes.NewReader(reqBody))
+// NewHTTPClient returns an HTTP client suitable for use in a connection's HTTPClient field, with
+// the same timeouts as the default HttpClient, securing connections to Eureka servers with the
+// given TLS configuration. If tlsConfig is nil, the client uses the default TLS configuration.
+func NewHTTPClient(tlsConfig *tls.Config) *http.Client {
+	return &http.Client{
+		Transport: newTransport(tlsConfig),
+		Timeout:   30 * time.Second,
+	}
+}
+
+func (e *EurekaConnection) httpClient() *http.Client {
+	if e.HTTPClient != nil {
+		return e.HTTPClient
+	}
+	return HttpClient
+}
+
+func (e *EurekaConnection) postBody(ctx context.Context, path string, reqBody []byte, isJson bool) ([]byte, int, error) {
+	req, err := http.NewRequest("POST", path, bytes.NewReader(reqBody))
 	if err != nil {
-		log.Errorf("Could not create POST %s with body %s, error: %s", reqURL, string(reqBody), err.Error())
+		e.logger().Error("Could not create POST request", "path", path, "body", string(reqBody), "error", err)
 		return nil, -1, err
 	}
-	log.Debugf("postBody: %s %s : %s\n", req.Method, req.URL, string(reqBody))
-	body, rcode, err := netReqTyped(req, isJson)
+	req = req.WithContext(ctx)
+	e.logger().Debug("Sending POST request", "path", path, "body", string(reqBody))
+	body, rcode, err := e.netReqTyped(req, isJson)
 	if err != nil {
-		log.Errorf("Could not complete POST %s with body %s, error: %s", reqURL, string(reqBody), err.Error())
+		e.logger().Error("Could not complete POST request", "path", path, "body", string(reqBody), "error", err)
 		return nil, rcode, err
 	}
 	//eurekaCache.Flush()
 	return body, rcode, nil
 }
 
-func putKV(reqURL string, pairs map[string]string) ([]byte, int, error) {
+func (e *EurekaConnection) putKV(ctx context.Context, path string, pairs map[string]string) ([]byte, int, error) {
 	params := url.Values{}
 	for k, v := range pairs {
 		params.Add(k, v)
 	}
-	parameterizedURL := reqURL + "?" + params.Encode()
-	log.Noticef("Sending KV request with URL %s", parameterizedURL)
-	req, err := http.NewRequest("PUT", parameterizedURL, nil)
+	parameterizedPath := path + "?" + params.Encode()
+	e.logger().Debug("Sending KV request", "path", parameterizedPath)
+	req, err := http.NewRequest("PUT", parameterizedPath, nil)
 	if err != nil {
-		log.Errorf("Could not create PUT %s, error: %s", reqURL, err.Error())
+		e.logger().Error("Could not create PUT request", "path", path, "error", err)
 		return nil, -1, err
 	}
-	body, rcode, err := netReq(req) // TODO(cq) I think this can just be netReq() since there is no body
+	req = req.WithContext(ctx)
+	body, rcode, err := e.netReq(req) // TODO(cq) I think this can just be netReq() since there is no body
 	if err != nil {
-		log.Errorf("Could not complete PUT %s, error: %s", reqURL, err.Error())
+		e.logger().Error("Could not complete PUT request", "path", path, "error", err)
 		return nil, rcode, err
 	}
 	return body, rcode, nil
 }
 
-func getBody(reqURL string, isJson bool) ([]byte, int, error) {
-	req, err := http.NewRequest("GET", reqURL, nil)
+func (e *EurekaConnection) getBody(ctx context.Context, path string, isJson bool) ([]byte, int, error) {
+	req, err := http.NewRequest("GET", path, nil)
 	if err != nil {
-		log.Errorf("Could not create GET %s, error: %s", reqURL, err.Error())
+		e.logger().Error("Could not create GET request", "path", path, "error", err)
 		return nil, -1, err
 	}
-	body, rcode, err := netReqTyped(req, isJson)
+	req = req.WithContext(ctx)
+	body, rcode, err := e.netReqTyped(req, isJson)
 	if err != nil {
-		log.Errorf("Could not complete GET %s, error: %s", reqURL, err.Error())
+		e.logger().Error("Could not complete GET request", "path", path, "error", err)
 		return nil, rcode, err
 	}
 	return body, rcode, nil
 }
 
-func deleteReq(reqURL string) (int, error) {
-	req, err := http.NewRequest("DELETE", reqURL, nil)
+func (e *EurekaConnection) deleteReq(ctx context.Context, path string) (int, error) {
+	req, err := http.NewRequest("DELETE", path, nil)
 	if err != nil {
-		log.Errorf("Could not create DELETE %s, error: %s", reqURL, err.Error())
+		e.logger().Error("Could not create DELETE request", "path", path, "error", err)
 		return -1, err
 	}
-	_, rcode, err := netReq(req)
+	req = req.WithContext(ctx)
+	_, rcode, err := e.netReq(req)
 	if err != nil {
-		log.Errorf("Could not complete DELETE %s, error: %s", reqURL, err.Error())
+		e.logger().Error("Could not complete DELETE request", "path", path, "error", err)
 		return rcode, err
 	}
 	return rcode, nil
 }
 
-func netReqTyped(req *http.Request, isJson bool) ([]byte, int, error) {
+func (e *EurekaConnection) netReqTyped(req *http.Request, isJson bool) ([]byte, int, error) {
 	if isJson {
 		req.Header.Set("Content-Type", "application/json")
 		req.Header.Set("Accept", "application/json")
@@ -95,34 +126,105 @@ func netReqTyped(req *http.Request, isJson bool) ([]byte, int, error) {
 		req.Header.Set("Content-Type", "application/xml")
 		req.Header.Set("Accept", "application/xml")
 	}
-	return netReq(req)
+	return e.netReq(req)
 }
 
-func netReq(req *http.Request) ([]byte, int, error) {
-	var resp *http.Response
-	var err error
-	for i := 0; i < 3; i++ {
-		resp, err = HttpClient.Do(req)
-		if nerr, ok := err.(net.Error); ok && nerr.Temporary() {
-			// it's a transient network error so we sleep for a bit and try
-			// again in case it's a short-lived issue
-			log.Warningf("Retrying after temporary network failure, error: %s",
-				nerr.Error())
-			time.Sleep(10)
+// netReq sends the given request to Eureka. A request with a relative URL is resolved against a
+// service URL selected anew for each attempt; should a server fail to respond, or respond with a
+// 5xx status code, netReq quarantines that server and retries the request against another one, up
+// to the number of times allowed by the connection's Retries field. A request with an absolute
+// URL is sent only to the server it names. A request that fails before reaching any server, such
+// as for want of credentials, fails at once, without reflecting on the server.
+func (e *EurekaConnection) netReq(req *http.Request) ([]byte, int, error) {
+	if req.URL.IsAbs() {
+		body, rcode, err := e.send(req, "")
+		if le, ok := err.(*localError); ok {
+			err = le.err
+		}
+		return body, rcode, err
+	}
+	ctx := req.Context()
+	attempts := e.attempts()
+	var tried []string
+	for i := 1; ; i++ {
+		serviceURL := e.selectServiceURL(ctx, tried...)
+		tried = append(tried, serviceURL)
+		body, rcode, err := e.send(req, serviceURL)
+		if le, ok := err.(*localError); ok {
+			return nil, -1, le.err
+		}
+		if err == nil && rcode < 500 {
+			quarantine.succeeded(serviceURL)
+			return body, rcode, nil
+		}
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			// The caller gave up, which says nothing about the server's health.
+			return nil, -1, ctxErr
+		}
+		d := quarantine.failed(serviceURL)
+		e.logger().Warn("Quarantining failing Eureka server", "url", redactURL(serviceURL), "duration", d)
+		if i >= attempts {
+			return body, rcode, err
+		}
+		if err != nil {
+			e.logger().Warn("Retrying against another server after failure", "url", redactURL(serviceURL), "error", err)
 		} else {
-			break
+			e.logger().Warn("Retrying against another server after failure", "url", redactURL(serviceURL), "status", rcode)
 		}
 	}
+}
+
+// localError wraps an error that arose in preparing a request, before sending it to any server.
+type localError struct {
+	err error
+}
+
+func (e *localError) Error() string {
+	return e.err.Error()
+}
+
+// send makes a single attempt to complete the given request, resolving its URL against the given
+// service URL unless that's empty. It returns a *localError should it fail to prepare the request.
+func (e *EurekaConnection) send(req *http.Request, serviceURL string) ([]byte, int, error) {
+	ctx := req.Context()
+	if e.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, e.Timeout)
+		defer cancel()
+	}
+	r := req.WithContext(ctx)
+	r.Header = req.Header.Clone()
+	if len(serviceURL) > 0 {
+		u, err := url.Parse(strings.TrimSuffix(serviceURL, "/") + "/" + req.URL.String())
+		if err != nil {
+			e.logger().Error("Could not resolve request path", "path", req.URL, "url", redactURL(serviceURL), "error", err)
+			return nil, -1, &localError{err}
+		}
+		r.URL = u
+	}
+	if req.GetBody != nil {
+		// Each attempt needs its own copy of the body, as a failed attempt may have consumed it.
+		body, err := req.GetBody()
+		if err != nil {
+			return nil, -1, &localError{err}
+		}
+		r.Body = body
+	}
+	if err := e.authorize(r); err != nil {
+		e.logger().Error("Could not authorize request", "url", redactURL(r.URL.String()), "error", err)
+		return nil, -1, &localError{err}
+	}
+	resp, err := e.httpClient().Do(r)
 	if err != nil {
 		return nil, -1, err
 	}
 	defer resp.Body.Close()
 	body, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
-		log.Errorf("Failure reading request body, error: %s", err.Error())
+		e.logger().Error("Failure reading response body", "url", redactURL(r.URL.String()), "error", err)
 		return nil, -1, err
 	}
 	// At this point we're done and shit worked, simply return the bytes
-	log.Infof("Got eureka response from url=%v", req.URL)
+	e.logger().Debug("Got Eureka response", "url", redactURL(r.URL.String()), "status", resp.StatusCode)
 	return body, resp.StatusCode, nil
 }
@@ -1,10 +1,17 @@
 package fargo
 
 import (
+	"context"
+	"encoding/pem"
+	"errors"
 	"fmt"
+	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
 	"testing"
+	"time"
 
 	. "github.com/smartystreets/goconvey/convey"
 )
@@ -18,6 +25,12 @@ func (r *roundtripper) RoundTrip(req *http.Request) (*http.Response, error) {
 	return http.DefaultTransport.RoundTrip(req)
 }
 
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
 func TestHttpClient(t *testing.T) {
 	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		fmt.Fprint(w, "Hello World")
@@ -34,12 +47,254 @@ func TestHttpClient(t *testing.T) {
 			req, err := http.NewRequest("GET", server.URL, nil)
 			So(err, ShouldBeNil)
 
-			respBody, respCode, err := netReq(req)
+			var e EurekaConnection
+			respBody, respCode, err := e.netReq(req)
 			So(err, ShouldBeNil)
 			So(respCode, ShouldEqual, 200)
 			So(string(respBody), ShouldEqual, "Hello World")
 
 			So(rt.TripCount, ShouldEqual, 1)
 		})
+
+		Convey("and a connection has its own client", func() {
+			ownRT := new(roundtripper)
+			e := EurekaConnection{
+				HTTPClient: &http.Client{
+					Transport: ownRT,
+				},
+			}
+
+			Convey("netReq uses the connection's client instead", func() {
+				req, err := http.NewRequest("GET", server.URL, nil)
+				So(err, ShouldBeNil)
+
+				_, respCode, err := e.netReq(req)
+				So(err, ShouldBeNil)
+				So(respCode, ShouldEqual, 200)
+
+				So(ownRT.TripCount, ShouldEqual, 1)
+				So(rt.TripCount, ShouldEqual, 0)
+			})
+		})
+	})
+}
+
+func TestConnectionTimeout(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer server.Close()
+	defer close(release)
+
+	Convey("Given a connection with a timeout", t, func() {
+		e := NewConn(server.URL)
+		e.HTTPClient = &http.Client{Transport: http.DefaultTransport}
+		e.Timeout = 10 * time.Millisecond
+
+		Convey("requests to an unresponsive server fail promptly", func() {
+			start := time.Now()
+			_, err := e.GetApp("TESTAPP")
+			So(err, ShouldNotBeNil)
+			So(time.Since(start), ShouldBeLessThan, 5*time.Second)
+		})
+	})
+}
+
+func TestTLSOptions(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "Hello World")
+	}))
+	defer server.Close()
+
+	Convey("Given a Eureka server with a self-signed certificate", t, func() {
+		dir, err := ioutil.TempDir("", "fargo")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+		caFile := filepath.Join(dir, "ca.pem")
+		ca := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+		So(ioutil.WriteFile(caFile, ca, 0600), ShouldBeNil)
+
+		req, err := http.NewRequest("GET", server.URL, nil)
+		So(err, ShouldBeNil)
+
+		Convey("a client that trusts the default CAs rejects the server", func() {
+			e := EurekaConnection{HTTPClient: NewHTTPClient(nil)}
+			_, _, err := e.netReq(req)
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("a client that trusts the server's CA accepts the server", func() {
+			tlsConfig, err := (&TLSOptions{CAFile: caFile, ServerName: "example.com"}).TLSConfig()
+			So(err, ShouldBeNil)
+			e := EurekaConnection{HTTPClient: NewHTTPClient(tlsConfig)}
+			body, rcode, err := e.netReq(req)
+			So(err, ShouldBeNil)
+			So(rcode, ShouldEqual, 200)
+			So(string(body), ShouldEqual, "Hello World")
+		})
+
+		Convey("a CA file lacking certificates is rejected", func() {
+			_, err := (&TLSOptions{CAFile: filepath.Join(dir, "missing.pem")}).TLSConfig()
+			So(err, ShouldNotBeNil)
+			So(ioutil.WriteFile(caFile, []byte("bogus"), 0600), ShouldBeNil)
+			_, err = (&TLSOptions{CAFile: caFile}).TLSConfig()
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("a client certificate lacking a key is rejected", func() {
+			_, err := (&TLSOptions{CertFile: caFile}).TLSConfig()
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
+
+func TestRequestContext(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer server.Close()
+	defer close(release)
+
+	Convey("Given a Eureka server that never responds", t, func() {
+		e := NewConn(server.URL)
+		e.HTTPClient = &http.Client{Transport: http.DefaultTransport}
+
+		Convey("a request whose context is canceled fails promptly", func() {
+			ctx, cancel := context.WithCancel(context.Background())
+			time.AfterFunc(10*time.Millisecond, cancel)
+			start := time.Now()
+			_, err := e.GetAppContext(ctx, "TESTAPP")
+			So(err, ShouldNotBeNil)
+			So(time.Since(start), ShouldBeLessThan, 5*time.Second)
+		})
+
+		Convey("a request whose deadline passes fails promptly", func() {
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+			defer cancel()
+			start := time.Now()
+			err := e.HeartBeatInstanceContext(ctx, &Instance{App: "TESTAPP", InstanceId: "i-123"})
+			So(err, ShouldNotBeNil)
+			So(time.Since(start), ShouldBeLessThan, 5*time.Second)
+		})
+	})
+}
+
+func TestFailover(t *testing.T) {
+	var failures, successes int
+	var bodies []string
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		failures++
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer failing.Close()
+	healthy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		successes++
+		body, _ := ioutil.ReadAll(r.Body)
+		bodies = append(bodies, string(body))
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer healthy.Close()
+	dead := httptest.NewServer(http.NotFoundHandler())
+	dead.Close()
+
+	Convey("Given Eureka servers of which only one is healthy", t, func() {
+		tried := make(map[string]struct{})
+		client := &http.Client{Transport: roundTripperFunc(func(r *http.Request) (*http.Response, error) {
+			tried["http://"+r.URL.Host] = struct{}{}
+			return http.DefaultTransport.RoundTrip(r)
+		})}
+		failures, successes, bodies = 0, 0, nil
+		defer func() {
+			for _, u := range []string{failing.URL, healthy.URL, dead.URL} {
+				quarantine.succeeded(u)
+			}
+		}()
+
+		Convey("a request fails over to the healthy server", func() {
+			e := NewConn(failing.URL, dead.URL, healthy.URL)
+			e.HTTPClient = client
+			for i := 0; i != 5; i++ {
+				_, rcode, err := e.postBody(context.Background(), "apps/TESTAPP", []byte("registration"), false)
+				So(err, ShouldBeNil)
+				So(rcode, ShouldEqual, http.StatusNoContent)
+			}
+			So(successes, ShouldEqual, 5)
+			So(bodies, ShouldResemble, []string{"registration", "registration", "registration", "registration", "registration"})
+
+			Convey("and the failing servers are passed over while quarantined", func() {
+				So(failures, ShouldBeLessThanOrEqualTo, 1)
+				// Servers are chosen at random, so either failing server may have gone untried.
+				for _, u := range []string{failing.URL, dead.URL} {
+					if _, ok := tried[u]; ok {
+						So(quarantine.available([][]string{e.ServiceUrls}, nil), ShouldNotContain, u)
+					}
+				}
+				So(quarantine.available([][]string{e.ServiceUrls}, nil), ShouldContain, healthy.URL)
+			})
+		})
+
+		Convey("a connection that forbids retries gives up after one attempt", func() {
+			e := NewConn(failing.URL, healthy.URL)
+			e.HTTPClient = client
+			e.Retries = -1
+			quarantine.failed(healthy.URL)
+			_, rcode, err := e.postBody(context.Background(), "apps/TESTAPP", nil, false)
+			So(err, ShouldBeNil)
+			So(rcode, ShouldEqual, http.StatusServiceUnavailable)
+			So(failures, ShouldEqual, 1)
+			So(successes, ShouldEqual, 0)
+		})
+
+		Convey("a request that can't be authorized fails without quarantining a server", func() {
+			e := NewConn(healthy.URL, failing.URL)
+			e.HTTPClient = client
+			var asked int
+			e.Authorizer = BearerToken(TokenProviderFunc(func(context.Context) (string, error) {
+				asked++
+				return "", errors.New("no token")
+			}))
+			_, _, err := e.postBody(context.Background(), "apps/TESTAPP", nil, false)
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "no token")
+			So(asked, ShouldEqual, 1)
+			So(failures+successes, ShouldEqual, 0)
+			So(quarantine.available([][]string{e.ServiceUrls}, nil), ShouldResemble, e.ServiceUrls)
+		})
+
+		Convey("a request retries a lone server", func() {
+			e := NewConn(failing.URL)
+			e.HTTPClient = client
+			e.Retries = 2
+			_, rcode, err := e.postBody(context.Background(), "apps/TESTAPP", nil, false)
+			So(err, ShouldBeNil)
+			So(rcode, ShouldEqual, http.StatusServiceUnavailable)
+			So(failures, ShouldEqual, 3)
+		})
+	})
+}
+
+func TestQuarantine(t *testing.T) {
+	Convey("A server that fails repeatedly is quarantined for progressively longer", t, func() {
+		q := &serverQuarantine{entries: make(map[string]*quarantineEntry)}
+		q.failed("http://a")
+		first := q.entries["http://a"].until
+		q.failed("http://a")
+		So(q.entries["http://a"].until, ShouldHappenAfter, first.Add(minQuarantine/2))
+		for i := 0; i != 20; i++ {
+			q.failed("http://a")
+		}
+		So(q.entries["http://a"].until, ShouldHappenBefore, time.Now().Add(maxQuarantine+time.Second))
+
+		Convey("until it succeeds", func() {
+			q.succeeded("http://a")
+			So(q.available([][]string{{"http://a", "http://b"}}, nil), ShouldResemble, []string{"http://a", "http://b"})
+		})
+
+		Convey("but is still chosen when every server is quarantined", func() {
+			So(q.available([][]string{{"http://a"}}, nil), ShouldResemble, []string{"http://a"})
+			So(q.available([][]string{{"http://a"}}, []string{"http://a"}), ShouldResemble, []string{"http://a"})
+		})
 	})
 }
@@ -0,0 +1,74 @@
+package fargo_test
+
+// MIT Licensed (see README.md) - Copyright (c) 2013 Hudl <@Hudl>
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/hudl/fargo"
+	"github.com/hudl/fargo/fargotest"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestStatusOverrides(t *testing.T) {
+	Convey("Given a registered instance", t, func() {
+		s := fargotest.NewServer()
+		defer s.Close()
+		e := fargo.NewConn(s.URL)
+		e.Logger = fargo.NoopLogger{}
+		e.Retries = -1
+		ins := &fargo.Instance{
+			InstanceId:     "i-1",
+			HostName:       "i-1.example.com",
+			App:            "TESTAPP",
+			IPAddr:         "10.0.0.1",
+			VipAddress:     "testapp",
+			Status:         fargo.UP,
+			DataCenterInfo: fargo.DataCenterInfo{Name: fargo.MyOwn},
+		}
+		So(e.RegisterInstance(ins), ShouldBeNil)
+		registered := func() *fargo.Instance {
+			instances := s.Instances("TESTAPP")
+			So(instances, ShouldHaveLength, 1)
+			return instances[0]
+		}
+
+		Convey("its status can be overridden", func() {
+			So(e.SetStatusOverride(ins, fargo.OUTOFSERVICE), ShouldBeNil)
+			So(registered().Status, ShouldEqual, fargo.OUTOFSERVICE)
+			So(registered().Overriddenstatus, ShouldEqual, fargo.OUTOFSERVICE)
+			So(ins.Overriddenstatus, ShouldEqual, fargo.OUTOFSERVICE)
+
+			Convey("and the override removed, falling back to a given status", func() {
+				So(e.RemoveStatusOverride(ins, fargo.UP), ShouldBeNil)
+				So(registered().Status, ShouldEqual, fargo.UP)
+				So(registered().Overriddenstatus, ShouldEqual, fargo.UNKNOWN)
+				So(ins.Status, ShouldEqual, fargo.UP)
+				So(ins.Overriddenstatus, ShouldEqual, fargo.UNKNOWN)
+			})
+
+			Convey("or removed without a fallback status", func() {
+				So(e.RemoveStatusOverride(ins, ""), ShouldBeNil)
+				So(registered().Status, ShouldEqual, fargo.OUTOFSERVICE)
+				So(registered().Overriddenstatus, ShouldEqual, fargo.UNKNOWN)
+			})
+		})
+
+		Convey("an unknown instance is distinguished from a refusal", func() {
+			missing := *ins
+			missing.InstanceId = "i-2"
+			err := e.SetStatusOverride(&missing, fargo.OUTOFSERVICE)
+			So(err, ShouldHaveSameTypeAs, fargo.InstanceNotFoundError{})
+			err = e.RemoveStatusOverride(&missing, fargo.UP)
+			So(err, ShouldHaveSameTypeAs, fargo.InstanceNotFoundError{})
+
+			s.SetFailureHook(func(*http.Request) int { return http.StatusInternalServerError })
+			err = e.RemoveStatusOverride(ins, fargo.UP)
+			So(err, ShouldNotHaveSameTypeAs, fargo.InstanceNotFoundError{})
+			code, present := fargo.HTTPResponseStatusCode(err)
+			So(present, ShouldBeTrue)
+			So(code, ShouldEqual, http.StatusInternalServerError)
+		})
+	})
+}
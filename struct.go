@@ -3,6 +3,7 @@ package fargo
 // MIT Licensed (see README.md) - Copyright (c) 2013 Hudl <@Hudl>
 
 import (
+	"net/http"
 	"time"
 )
 
@@ -15,6 +16,14 @@ var EurekaURLSlugs = map[string]string{
 }
 
 // EurekaConnection is the settings required to make Eureka requests.
+//
+// If Timeout is positive, it bounds the time allowed for each attempt to complete a request to
+// Eureka.
+//
+// Should a server fail to respond to a request, or respond with a 5xx status code, the connection
+// retries the request against another server, passing over the failing server for a while in
+// subsequent requests. Retries bounds the number of such retries for each request: if zero, the
+// connection retries up to three times, and if negative, it doesn't retry at all.
 type EurekaConnection struct {
 	ServiceUrls    []string
 	ServicePort    int
@@ -25,8 +34,43 @@ type EurekaConnection struct {
 	Retries        int
 	DNSDiscovery   bool
 	DiscoveryZone  string
-	discoveryTtl   chan struct{}
 	UseJson        bool
+	EnableDelta    bool
+	// HTTPClient sends this connection's requests to Eureka. If nil, the connection uses the
+	// package-level HttpClient instead.
+	HTTPClient *http.Client
+	// Authorizer supplies credentials for requests to service URLs that lack embedded
+	// credentials. If nil, such requests carry no credentials.
+	Authorizer Authorizer
+	// Logger records the messages logged on behalf of this connection. If nil, the connection uses
+	// the package-level Logger set with SetLogger.
+	Logger Logger
+	// Zone names the zone in which this connection's client runs, such as "us-east-1a".
+	Zone string
+	// ServiceUrlsByZone maps zone names to the service URLs of the Eureka servers in each zone.
+	// The connection prefers servers in zones that come earlier in the order described by
+	// AvailabilityZones and PreferSameZone, moving on to later zones only while every server in
+	// the earlier ones is failing, and to the servers in ServiceUrls after that.
+	ServiceUrlsByZone map[string][]string
+	// AvailabilityZones orders the zones in ServiceUrlsByZone. Zones it doesn't list follow those
+	// it does, in order by name. If PreferSameZone is true, the order starts from the connection's
+	// own zone; otherwise, it starts from the first zone other than the connection's own.
+	AvailabilityZones []string
+	// DiscoveryRegion names the region in whose zones DNS discovery finds Eureka servers. If empty,
+	// it's the region of the EC2 instance on which the connection runs, or "us-east-1" elsewhere.
+	DiscoveryRegion string
+	// DiscoveryZones lists the zones within DiscoveryRegion in which DNS discovery finds Eureka
+	// servers, such as "us-east-1a", each looked up under txt.<zone>.<DiscoveryZone>. If empty, the
+	// zones are those listed in the region's TXT record.
+	DiscoveryZones []string
+	// DNSResolver answers the queries made for DNS discovery. If nil, the queries go to the name
+	// servers listed in /etc/resolv.conf, each in turn should those before it fail.
+	DNSResolver DNSResolver
+	// DiscoverySRV selects DNS discovery via the SRV records published under DiscoveryZone, such as
+	// "_eureka._tcp.example.com", in place of TXT records for the zones in DiscoveryRegion. The
+	// connection prefers the servers with the lowest priority, choosing among them per their
+	// weights.
+	DiscoverySRV bool
 }
 
 // GetAppsResponseJson lets us deserialize the eureka/v2/apps response JSON—a wrapped GetAppsResponse.
@@ -64,6 +108,16 @@ const (
 	UNKNOWN      StatusType = "UNKNOWN"
 )
 
+// ActionType is an enum of the changes to an instance reported in a registry delta.
+type ActionType string
+
+// Supported action types
+const (
+	ADDED    ActionType = "ADDED"
+	MODIFIED ActionType = "MODIFIED"
+	DELETED  ActionType = "DELETED"
+)
+
 // Datacenter names
 const (
 	Amazon = "Amazon"
@@ -102,6 +156,10 @@ type Instance struct {
 	LeaseInfo LeaseInfo        `xml:"leaseInfo" json:"leaseInfo"`
 	Metadata  InstanceMetadata `xml:"metadata" json:"metadata"`
 
+	// ActionType is populated only for instances retrieved as part of a registry delta. See
+	// GetAppsDelta for details.
+	ActionType ActionType `xml:"actionType,omitempty" json:"actionType,omitempty"`
+
 	UniqueID func(i Instance) string `xml:"-" json:"-"`
 }
 
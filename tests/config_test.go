@@ -35,4 +35,102 @@ func TestConfigs(t *testing.T) {
 		})
 		So(conf.Eureka.UseDNSForServiceUrls, ShouldEqual, false)
 	})
+
+	Convey("Testing a config that lists service URLs by zone", t, func() {
+		conf, err := fargo.ReadConfig("./config_sample/zones.gcfg")
+		So(err, ShouldBeNil)
+		So(conf.Zone, ShouldContainKey, "us-east-1b")
+		So(conf.Zone["us-east-1b"].ServiceUrls, ShouldHaveLength, 2)
+		Convey("The connection uses both the zone sections and the legacy AWS fields", func() {
+			e := fargo.NewConnFromConfig(conf)
+			So(e.Zone, ShouldEqual, "us-east-1b")
+			So(e.PreferSameZone, ShouldBeTrue)
+			So(e.ServiceUrlsByZone, ShouldHaveLength, 3)
+			So(e.ServiceUrlsByZone["us-east-1a"], ShouldResemble, []string{"http://eureka1.east1a.example.com:8080/eureka/v2"})
+			So(e.ServiceUrlsByZone["us-west-2a"], ShouldResemble, []string{"http://eureka1.west2a.example.com:8080/eureka/v2"})
+			So(e.SelectServiceURL(), ShouldContainSubstring, "east1b")
+		})
+	})
+
+	for _, sample := range []struct {
+		kind string
+		read func(string) (fargo.Config, error)
+		file string
+	}{
+		{"properties", fargo.ReadPropertiesConfig, "./config_sample/spring.properties"},
+		{"YAML", fargo.ReadYAMLConfig, "./config_sample/application.yml"},
+	} {
+		Convey("Reading a Spring-style "+sample.kind+" config", t, func() {
+			conf, err := sample.read(sample.file)
+			So(err, ShouldBeNil)
+			So(conf.AWS.Region, ShouldEqual, "us-west-2")
+			So(conf.Eureka.ServiceUrls, ShouldResemble, []string{
+				"http://eureka1.example.com:8080/eureka/v2/",
+				"http://eureka2.example.com:8080/eureka/v2/",
+			})
+			So(conf.Zone, ShouldContainKey, "us-west-2a")
+			So(conf.Zone["us-west-2a"].ServiceUrls, ShouldResemble, []string{"http://eureka1.west2a.example.com:8080/eureka/v2"})
+			So(conf.AWS.AvailabilityZones, ShouldResemble, []string{"us-west-2a", "us-west-2b"})
+			So(conf.Eureka.RegisterWithEureka, ShouldBeTrue)
+			So(conf.Eureka.PollIntervalSeconds, ShouldEqual, 15)
+			So(conf.Eureka.PreferSameZone, ShouldBeTrue)
+			So(conf.Eureka.EnableDelta, ShouldBeTrue)
+			So(conf.Eureka.ConnectTimeoutSeconds, ShouldEqual, 10)
+			So(conf.Instance.Port, ShouldEqual, 8080)
+			So(conf.Instance.Metadata, ShouldResemble, map[string]string{"version": "1.2.3", "team": "checkout and payments"})
+
+			Convey("The connection and instance follow the config", func() {
+				e := fargo.NewConnFromConfig(conf)
+				So(e.Zone, ShouldEqual, "us-west-2a")
+				So(e.SelectServiceURL(), ShouldContainSubstring, "west2a")
+
+				ins, err := conf.NewInstanceBuilder().WithHostName("host.example.com").WithIPAddress("10.0.0.1").Build()
+				So(err, ShouldBeNil)
+				So(ins.App, ShouldEqual, "TESTAPP")
+				So(ins.Port, ShouldEqual, 8080)
+				So(ins.LeaseInfo.RenewalIntervalInSecs, ShouldEqual, 10)
+				So(ins.LeaseInfo.DurationInSecs, ShouldEqual, 30)
+				So(ins.Metadata.GetMap()["team"], ShouldEqual, "checkout and payments")
+			})
+		})
+	}
+
+	Convey("Reading the Eureka client properties used with the Docker images", t, func() {
+		conf, err := fargo.ReadPropertiesConfig("../docker/eureka-client-test.properties")
+		So(err, ShouldBeNil)
+		So(conf.Eureka.ServiceUrls, ShouldResemble, []string{
+			"http://172.17.0.2:8080/eureka/v2/",
+			"http://172.17.0.3:8080/eureka/v2/",
+		})
+		So(conf.Instance.VIPAddress, ShouldEqual, "eureka")
+	})
+
+	Convey("Reading a properties config with a malformed value fails", t, func() {
+		_, err := fargo.ReadPropertiesConfig("./config_sample/invalid.properties")
+		So(err, ShouldNotBeNil)
+		So(err.Error(), ShouldContainSubstring, "eureka.client.registryFetchIntervalSeconds")
+	})
+
+	Convey("Reading a config overlaid by the environment", t, func() {
+		t.Setenv("FARGO_EUREKA_SERVICEURLS", "http://eureka1.example.com:8080/eureka/v2")
+		t.Setenv("FARGO_EUREKA_POLLINTERVALSECONDS", "15")
+		conf, err := fargo.ReadConfig("./config_sample/local.gcfg")
+		So(err, ShouldBeNil)
+		So(conf.Eureka.ServiceUrls, ShouldResemble, []string{"http://eureka1.example.com:8080/eureka/v2"})
+		So(conf.Eureka.PollIntervalSeconds, ShouldEqual, 15)
+		So(conf.Eureka.ConnectTimeoutSeconds, ShouldEqual, 2)
+
+		Convey("or by the environment alone", func() {
+			conf, err := fargo.ReadConfigFromEnvironment()
+			So(err, ShouldBeNil)
+			So(conf.Eureka.ServiceUrls, ShouldResemble, []string{"http://eureka1.example.com:8080/eureka/v2"})
+			So(conf.Eureka.ConnectTimeoutSeconds, ShouldEqual, 10)
+		})
+
+		Convey("failing on malformed values", func() {
+			t.Setenv("FARGO_EUREKA_RETRIES", "many")
+			_, err := fargo.ReadConfig("./config_sample/local.gcfg")
+			So(err, ShouldNotBeNil)
+		})
+	})
 }
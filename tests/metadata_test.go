@@ -8,6 +8,7 @@ import (
 	. "github.com/smartystreets/goconvey/convey"
 	"strconv"
 	"testing"
+	"time"
 )
 
 func TestGetInt(t *testing.T) {
@@ -84,3 +85,161 @@ func TestSerializeMeta(t *testing.T) {
 		})
 	})
 }
+
+func TestMetadataRewrite(t *testing.T) {
+	Convey("Given an instance with number-like metadata held as XML", t, func() {
+		ins := new(fargo.Instance)
+		ins.Metadata.Raw = []byte(`<version>2.0</version><port>0080</port><rate>1e10</rate><flag>true</flag>`)
+
+		Convey("Setting an item leaves the others' text alone", func() {
+			ins.SetMetadataString("color", "red")
+			So(string(ins.Metadata.Raw), ShouldEqual,
+				`<color>red</color><flag>true</flag><port>0080</port><rate>1e10</rate><version>2.0</version>`)
+			b, err := xml.Marshal(ins.Metadata)
+			So(err, ShouldBeNil)
+			So(string(b), ShouldContainSubstring, "<version>2.0</version>")
+			So(string(b), ShouldContainSubstring, "<port>0080</port>")
+		})
+
+		Convey("An item whose key isn't an XML name is refused", func() {
+			ins.SetMetadataString("not a name", "x")
+			So(string(ins.Metadata.Raw), ShouldEqual, `<version>2.0</version><port>0080</port><rate>1e10</rate><flag>true</flag>`)
+			err := ins.SetMetadata(struct {
+				Bad string `eureka:"<bad>"`
+			}{"x"})
+			So(err, ShouldNotBeNil)
+			merr, ok := err.(*fargo.MetadataError)
+			So(ok, ShouldBeTrue)
+			So(merr.Key, ShouldEqual, "<bad>")
+		})
+	})
+
+	Convey("Given an instance with number-like metadata held as JSON", t, func() {
+		ins := new(fargo.Instance)
+		ins.Metadata.Raw = []byte(`{"version":2.0,"port":"0080","rate":1e10}`)
+
+		Convey("Setting an item leaves the others' text alone", func() {
+			ins.SetMetadataString("color", "red")
+			So(string(ins.Metadata.Raw), ShouldEqual, `{"color":"red","port":"0080","rate":1e10,"version":2.0}`)
+			b, err := ins.Metadata.MarshalJSON()
+			So(err, ShouldBeNil)
+			So(string(b), ShouldEqual, `{"color":"red","port":"0080","rate":1e10,"version":2.0}`)
+		})
+	})
+}
+
+type buildMetadata struct {
+	SHA  string `eureka:"sha"`
+	Time time.Time
+}
+
+type serviceMetadata struct {
+	Version  string        `eureka:"version"`
+	Weight   float64       `eureka:"weight,omitempty"`
+	Secure   bool          `eureka:"secure"`
+	Port     *int          `eureka:"port"`
+	Timeout  time.Duration `eureka:"timeout"`
+	Features []string      `eureka:"features"`
+	Build    buildMetadata `eureka:"build"`
+	Ignored  string        `eureka:"-"`
+}
+
+func TestMetadataBinding(t *testing.T) {
+	Convey("Given a struct with tagged fields", t, func() {
+		port := 8443
+		built := time.Date(2016, 5, 1, 12, 0, 0, 0, time.UTC)
+		meta := serviceMetadata{
+			Version:  "1.2.0",
+			Secure:   true,
+			Port:     &port,
+			Timeout:  1500 * time.Millisecond,
+			Features: []string{"a", "b"},
+			Build:    buildMetadata{SHA: "abc123", Time: built},
+			Ignored:  "x",
+		}
+
+		Convey("SetMetadata renders each field as a string item", func() {
+			ins := new(fargo.Instance)
+			ins.SetMetadataString("existing", "kept")
+			So(ins.SetMetadata(&meta), ShouldBeNil)
+			So(ins.Metadata.GetMap(), ShouldResemble, map[string]interface{}{
+				"existing":   "kept",
+				"version":    "1.2.0",
+				"secure":     "true",
+				"port":       "8443",
+				"timeout":    "1.5s",
+				"features":   "a,b",
+				"build.sha":  "abc123",
+				"build.Time": "2016-05-01T12:00:00Z",
+			})
+
+			Convey("and Decode restores them", func() {
+				var decoded serviceMetadata
+				So(ins.Metadata.Decode(&decoded), ShouldBeNil)
+				meta.Ignored = ""
+				So(decoded, ShouldResemble, meta)
+			})
+
+			Convey("including after a round trip through XML", func() {
+				b, err := xml.Marshal(ins.Metadata)
+				So(err, ShouldBeNil)
+				var decoded fargo.InstanceMetadata
+				So(xml.Unmarshal(b, &decoded), ShouldBeNil)
+				var m serviceMetadata
+				So(decoded.Decode(&m), ShouldBeNil)
+				So(m.Build.SHA, ShouldEqual, "abc123")
+				So(m.Timeout, ShouldEqual, 1500*time.Millisecond)
+			})
+		})
+
+		Convey("Decode accepts the values present in JSON metadata", func() {
+			metadata := fargo.InstanceMetadata{Raw: []byte(`{"version":"2.0","weight":3,"secure":false,"features":["x","y"],"build":{"sha":"def"}}`)}
+			var m serviceMetadata
+			So(metadata.Decode(&m), ShouldBeNil)
+			So(m.Version, ShouldEqual, "2.0")
+			So(m.Weight, ShouldEqual, 3)
+			So(m.Features, ShouldResemble, []string{"x", "y"})
+			So(m.Build.SHA, ShouldEqual, "def")
+			So(m.Port, ShouldBeNil)
+		})
+
+		Convey("Decode leaves number-like strings as registered", func() {
+			var m struct {
+				Version string   `eureka:"version"`
+				Release string   `eureka:"release"`
+				Rate    string   `eureka:"rate"`
+				Flag    string   `eureka:"flag"`
+				Ports   []string `eureka:"ports"`
+			}
+			metadata := fargo.InstanceMetadata{Raw: []byte(`<version>2.0</version><release>1.10</release><rate>1e10</rate><flag>TRUE</flag><ports>0080,0443</ports>`)}
+			So(metadata.Decode(&m), ShouldBeNil)
+			So(m.Version, ShouldEqual, "2.0")
+			So(m.Release, ShouldEqual, "1.10")
+			So(m.Rate, ShouldEqual, "1e10")
+			So(m.Flag, ShouldEqual, "TRUE")
+			So(m.Ports, ShouldResemble, []string{"0080", "0443"})
+
+			metadata = fargo.InstanceMetadata{Raw: []byte(`{"version":2.0,"release":"1.10","rate":1e10}`)}
+			So(metadata.Decode(&m), ShouldBeNil)
+			So(m.Version, ShouldEqual, "2.0")
+			So(m.Release, ShouldEqual, "1.10")
+			So(m.Rate, ShouldEqual, "1e10")
+		})
+
+		Convey("Decode names the key that can't be stored", func() {
+			metadata := fargo.InstanceMetadata{Raw: []byte(`<version>1</version><timeout>soon</timeout>`)}
+			var m serviceMetadata
+			err := metadata.Decode(&m)
+			So(err, ShouldNotBeNil)
+			merr, ok := err.(*fargo.MetadataError)
+			So(ok, ShouldBeTrue)
+			So(merr.Key, ShouldEqual, "timeout")
+			So(m.Version, ShouldEqual, "1")
+		})
+
+		Convey("Decode requires a pointer to a struct", func() {
+			metadata := fargo.InstanceMetadata{}
+			So(metadata.Decode(meta), ShouldNotBeNil)
+		})
+	})
+}
@@ -0,0 +1,57 @@
+package fargo
+
+// MIT Licensed (see README.md) - Copyright (c) 2013 Hudl <@Hudl>
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+)
+
+// TLSOptions describes how to secure connections to Eureka servers with TLS.
+type TLSOptions struct {
+	// CAFile names a file containing a bundle of PEM-encoded CA certificates to trust when
+	// verifying Eureka servers' certificates, in place of the host's root CA set.
+	CAFile string
+	// CertFile and KeyFile name files containing a PEM-encoded client certificate and its private
+	// key to present to Eureka servers that require mutual TLS authentication.
+	CertFile string
+	KeyFile  string
+	// ServerName overrides the host name used to verify Eureka servers' certificates.
+	ServerName string
+}
+
+func (o *TLSOptions) isZero() bool {
+	return len(o.CAFile) == 0 && len(o.CertFile) == 0 && len(o.KeyFile) == 0 && len(o.ServerName) == 0
+}
+
+// TLSConfig returns a TLS configuration embodying these options, suitable for use with
+// NewHTTPClient. It returns an error if any of the named files can't be read or parsed.
+func (o *TLSOptions) TLSConfig() (*tls.Config, error) {
+	c := &tls.Config{
+		ServerName: o.ServerName,
+	}
+	if len(o.CAFile) > 0 {
+		pem, err := ioutil.ReadFile(o.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no CA certificates found in %s", o.CAFile)
+		}
+		c.RootCAs = pool
+	}
+	if len(o.CertFile) > 0 || len(o.KeyFile) > 0 {
+		if len(o.CertFile) == 0 || len(o.KeyFile) == 0 {
+			return nil, fmt.Errorf("a client certificate requires both a certificate file and a key file")
+		}
+		cert, err := tls.LoadX509KeyPair(o.CertFile, o.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		c.Certificates = []tls.Certificate{cert}
+	}
+	return c, nil
+}
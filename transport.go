@@ -0,0 +1,147 @@
+package fargo
+
+// MIT Licensed (see README.md) - Copyright (c) 2013 Hudl <@Hudl>
+
+import (
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// Transport is an http.RoundTripper that sends each request addressed to an application by
+// name, such as http://my-app/path, to an instance of that application picked by a Balancer. A
+// Transport may also be registered with an http.Transport for a custom scheme, such as
+// "eureka://my-app/path", in which case it sends requests via HTTPS if the balancer uses the
+// instances' secure ports, and via HTTP otherwise. Requests for hosts without a route pass through
+// to the base transport unchanged.
+//
+// Should a connection to the picked instance fail, the Transport retries the request against
+// another instance, provided that it can replay the request's body.
+type Transport struct {
+	// Base sends the rewritten requests. If nil, http.DefaultTransport is used.
+	Base http.RoundTripper
+	// Retries is the number of other instances to try after failing to connect to an instance.
+	// Zero means the default of three; a negative value disables retries.
+	Retries int
+
+	m      sync.RWMutex
+	routes map[string]*Balancer
+}
+
+// Route directs requests addressed to the given host name to the instances picked by the given
+// balancer, replacing any previous route for that name. Host names match case-insensitively, so
+// as to accommodate the upper-case names Eureka gives to applications. A nil balancer removes the
+// route.
+func (t *Transport) Route(name string, b *Balancer) {
+	name = strings.ToLower(name)
+	t.m.Lock()
+	defer t.m.Unlock()
+	if b == nil {
+		delete(t.routes, name)
+		return
+	}
+	if t.routes == nil {
+		t.routes = make(map[string]*Balancer)
+	}
+	t.routes[name] = b
+}
+
+func (t *Transport) balancerFor(name string) *Balancer {
+	t.m.RLock()
+	defer t.m.RUnlock()
+	return t.routes[strings.ToLower(name)]
+}
+
+func (t *Transport) base() http.RoundTripper {
+	if t.Base != nil {
+		return t.Base
+	}
+	return http.DefaultTransport
+}
+
+func (t *Transport) attempts() int {
+	switch {
+	case t.Retries > 0:
+		return t.Retries + 1
+	case t.Retries < 0:
+		return 1
+	default:
+		return defaultRetries + 1
+	}
+}
+
+// isDialError reports whether err arose from failing to connect, such that the request never
+// reached its destination and is safe to send elsewhere.
+func isDialError(err error) bool {
+	var opErr *net.OpError
+	return errors.As(err, &opErr) && opErr.Op == "dial"
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	name := req.URL.Hostname()
+	b := t.balancerFor(name)
+	if b == nil {
+		return t.base().RoundTrip(req)
+	}
+	scheme := req.URL.Scheme
+	if scheme != "http" && scheme != "https" {
+		scheme = "http"
+		if b.opts.secure {
+			scheme = "https"
+		}
+	}
+	attempts := t.attempts()
+	if req.Body != nil && req.Body != http.NoBody && req.GetBody == nil {
+		// Without a way to replay the body, there's only one chance to send it.
+		attempts = 1
+	}
+	var tried []string
+	for i := 1; ; i++ {
+		sel, err := b.pick(tried)
+		if err != nil {
+			if req.Body != nil {
+				req.Body.Close()
+			}
+			return nil, err
+		}
+		id := sel.Instance.Id()
+		tried = append(tried, id)
+		r := req.Clone(req.Context())
+		r.URL.Scheme = scheme
+		r.URL.Host = sel.Addr
+		r.Host = sel.Addr
+		if i > 1 && req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				sel.Done()
+				return nil, err
+			}
+			r.Body = body
+		}
+		resp, err := t.base().RoundTrip(r)
+		if err == nil {
+			resp.Body = &selectionBody{ReadCloser: resp.Body, sel: sel}
+			return resp, nil
+		}
+		sel.Done()
+		if i >= attempts || !isDialError(err) {
+			return nil, err
+		}
+		log().Warn("Retrying against another instance after failing to connect", "app", name, "instance", id, "error", err)
+	}
+}
+
+// selectionBody marks its selection as done once the response body is closed.
+type selectionBody struct {
+	io.ReadCloser
+	sel *Selection
+}
+
+func (b *selectionBody) Close() error {
+	defer b.sel.Done()
+	return b.ReadCloser.Close()
+}
@@ -0,0 +1,116 @@
+package fargo
+
+// MIT Licensed (see README.md) - Copyright (c) 2013 Hudl <@Hudl>
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// instanceAt returns an instance reachable at the given address.
+func instanceAt(id, addr string) *Instance {
+	host, port, _ := net.SplitHostPort(addr)
+	ins := instanceWith(id, UP, nil)
+	ins.HostName = host
+	fmt.Sscan(port, &ins.Port)
+	ins.PortEnabled = true
+	return ins
+}
+
+// unusedAddr returns an address at which nothing is listening.
+func unusedAddr() string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		panic(err)
+	}
+	defer l.Close()
+	return l.Addr().String()
+}
+
+func TestTransport(t *testing.T) {
+	Convey("Given a transport routing an application to its instances", t, func() {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			body, _ := ioutil.ReadAll(r.Body)
+			fmt.Fprintf(w, "%s %s %s", r.Method, r.URL.Path, body)
+		}))
+		defer server.Close()
+		serverAddr := strings.TrimPrefix(server.URL, "http://")
+		instances := []*Instance{instanceAt("i-1", serverAddr)}
+		produce := func() ([]*Instance, error) {
+			return instances, nil
+		}
+		e := EurekaConnection{PollInterval: time.Hour}
+		s := e.newInstanceSetSourceFor(produce, true)
+		defer s.Stop()
+		b := NewBalancer(s, RoundRobin())
+		defer b.Close()
+		transport := &Transport{Base: &http.Transport{}}
+		transport.Route("TESTAPP", b)
+		client := &http.Client{Transport: transport}
+
+		get := func(url string) (string, error) {
+			resp, err := client.Get(url)
+			if err != nil {
+				return "", err
+			}
+			defer resp.Body.Close()
+			body, err := ioutil.ReadAll(resp.Body)
+			return string(body), err
+		}
+
+		Convey("requests addressed to the application reach an instance", func() {
+			body, err := get("http://testapp/some/path")
+			So(err, ShouldBeNil)
+			So(body, ShouldEqual, "GET /some/path ")
+			So(b.Candidates()[0].InFlight(), ShouldEqual, 0)
+		})
+
+		Convey("requests via a custom scheme reach an instance", func() {
+			base := &http.Transport{}
+			base.RegisterProtocol("eureka", transport)
+			client.Transport = base
+			body, err := get("eureka://testapp/other")
+			So(err, ShouldBeNil)
+			So(body, ShouldEqual, "GET /other ")
+		})
+
+		Convey("requests for other hosts pass through unchanged", func() {
+			body, err := get(server.URL + "/direct")
+			So(err, ShouldBeNil)
+			So(body, ShouldEqual, "GET /direct ")
+		})
+
+		Convey("requests are retried against another instance when a connection fails", func() {
+			s.Stop()
+			instances = []*Instance{instanceAt("i-0", unusedAddr()), instanceAt("i-1", serverAddr)}
+			b.update(instances)
+			resp, err := client.Post("http://testapp/p", "text/plain", strings.NewReader("payload"))
+			So(err, ShouldBeNil)
+			defer resp.Body.Close()
+			body, _ := ioutil.ReadAll(resp.Body)
+			So(string(body), ShouldEqual, "POST /p payload")
+
+			Convey("unless retries are disabled", func() {
+				// Round robin comes back around to the unreachable instance.
+				transport.Retries = -1
+				_, err := get("http://testapp/p")
+				So(err, ShouldNotBeNil)
+			})
+		})
+
+		Convey("requests fail when no instances are available", func() {
+			b.update(nil)
+			_, err := get("http://testapp/p")
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, ErrNoInstances.Error())
+		})
+	})
+}
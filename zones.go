@@ -0,0 +1,60 @@
+package fargo
+
+// MIT Licensed (see README.md) - Copyright (c) 2013 Hudl <@Hudl>
+
+import (
+	"sort"
+)
+
+// zoneOrder returns the zones named in the connection's ServiceUrlsByZone map in the order in
+// which to consult them.
+//
+// The order follows AvailabilityZones, with any other zones following in order by name. Like the
+// Java client, if PreferSameZone is true, the order starts from the connection's own zone,
+// wrapping around to the zones listed before it; otherwise, it starts from the first zone other
+// than the connection's own.
+func (e *EurekaConnection) zoneOrder() []string {
+	zones := make([]string, 0, len(e.ServiceUrlsByZone))
+	for _, z := range e.AvailabilityZones {
+		if _, ok := e.ServiceUrlsByZone[z]; ok && !contains(zones, z) {
+			zones = append(zones, z)
+		}
+	}
+	var others []string
+	for z := range e.ServiceUrlsByZone {
+		if !contains(zones, z) {
+			others = append(others, z)
+		}
+	}
+	sort.Strings(others)
+	zones = append(zones, others...)
+
+	offset := 0
+	for i, z := range zones {
+		if (z == e.Zone) == e.PreferSameZone {
+			offset = i
+			break
+		}
+	}
+	ordered := make([]string, 0, len(zones))
+	return append(append(ordered, zones[offset:]...), zones[:offset]...)
+}
+
+// serviceURLTiers returns the connection's service URLs grouped into tiers in order of
+// preference: one for each zone in its ServiceUrlsByZone map, followed by one comprising its
+// ServiceUrls field. When the connection discovers its servers via DNS, there's just the latter.
+func (e *EurekaConnection) serviceURLTiers() [][]string {
+	if e.DNSDiscovery {
+		return [][]string{e.ServiceUrls}
+	}
+	var tiers [][]string
+	for _, z := range e.zoneOrder() {
+		if urls := e.ServiceUrlsByZone[z]; len(urls) > 0 {
+			tiers = append(tiers, urls)
+		}
+	}
+	if len(e.ServiceUrls) > 0 || len(tiers) == 0 {
+		tiers = append(tiers, e.ServiceUrls)
+	}
+	return tiers
+}
@@ -0,0 +1,74 @@
+package fargo
+
+// MIT Licensed (see README.md) - Copyright (c) 2013 Hudl <@Hudl>
+
+import (
+	"context"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestZoneOrder(t *testing.T) {
+	Convey("Given a connection with service URLs in several zones", t, func() {
+		e := EurekaConnection{
+			Zone: "us-east-1b",
+			ServiceUrlsByZone: map[string][]string{
+				"us-east-1a": {"http://a1", "http://a2"},
+				"us-east-1b": {"http://b1"},
+				"us-east-1c": {"http://c1"},
+				"eu-west-1a": {"http://e1"},
+			},
+			AvailabilityZones: []string{"us-east-1a", "us-east-1b", "us-east-1c"},
+		}
+
+		Convey("that prefers its own zone", func() {
+			e.PreferSameZone = true
+
+			Convey("the zones are ordered starting from its own", func() {
+				So(e.zoneOrder(), ShouldResemble, []string{"us-east-1b", "us-east-1c", "eu-west-1a", "us-east-1a"})
+			})
+
+			Convey("it selects servers in its own zone", func() {
+				for i := 0; i != 10; i++ {
+					So(e.SelectServiceURL(), ShouldEqual, "http://b1")
+				}
+			})
+
+			Convey("it falls back to the next zone while its own zone's servers are failing", func() {
+				quarantine.failed("http://b1")
+				defer quarantine.succeeded("http://b1")
+				So(e.SelectServiceURL(), ShouldEqual, "http://c1")
+				So(e.selectServiceURL(context.Background(), "http://c1"), ShouldEqual, "http://e1")
+			})
+		})
+
+		Convey("that doesn't prefer its own zone", func() {
+			e.PreferSameZone = false
+
+			Convey("the zones are ordered starting from the first other zone", func() {
+				So(e.zoneOrder(), ShouldResemble, []string{"us-east-1a", "us-east-1b", "us-east-1c", "eu-west-1a"})
+			})
+
+			Convey("it selects servers in the first other zone", func() {
+				So(e.SelectServiceURL(), ShouldBeIn, []string{"http://a1", "http://a2"})
+			})
+		})
+
+		Convey("and a list of service URLs outside any zone", func() {
+			e.ServiceUrls = []string{"http://x1"}
+
+			Convey("it consults that list only after every zone", func() {
+				tiers := e.serviceURLTiers()
+				So(tiers, ShouldHaveLength, 5)
+				So(tiers[4], ShouldResemble, []string{"http://x1"})
+			})
+		})
+	})
+
+	Convey("A connection without zones selects among its service URLs", t, func() {
+		e := NewConn("http://x1", "http://x2")
+		So(e.serviceURLTiers(), ShouldResemble, [][]string{{"http://x1", "http://x2"}})
+		So(e.SelectServiceURL(), ShouldBeIn, []string{"http://x1", "http://x2"})
+	})
+}